@@ -0,0 +1,198 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/SpringerPE/cf-user-role-syncher/cfclient"
+	"github.com/SpringerPE/cf-user-role-syncher/token"
+)
+
+// Will hold the roles in CF of the users managed by the app
+// Everything is read before the plan is made, so all decisions are based on the same state
+type actualState struct {
+	// Users which have the role, by role target key and lowercase username
+	// A nil entry means the users could not be listed
+	roleUsers map[string]map[string]cfclient.User
+	// Users associated with the org (OrgUser), by org GUID and lowercase username
+	// A nil entry means the users could not be listed
+	orgUsers map[string]map[string]cfclient.User
+	// GUIDs of the desired users which already exist, by lowercase username
+	userGuids map[string]string
+	// Desired users which don't exist yet in CF/UAA
+	missingUsers map[string]bool
+	// All roles of the users who lose a role, by user GUID
+	// Missing when the roles could not be listed
+	userRoles map[string][]cfclient.UserRole
+}
+
+// Reads the actual state in CF for the desired targets
+// Errors are counted in the summary. Whatever could not be read is left unknown, and the plan is made accordingly
+func readActualState(desired map[string]*desiredTarget, summary *cycleSummary) *actualState {
+	actual := &actualState{
+		roleUsers:    make(map[string]map[string]cfclient.User),
+		orgUsers:     make(map[string]map[string]cfclient.User),
+		userGuids:    make(map[string]string),
+		missingUsers: make(map[string]bool),
+		userRoles:    make(map[string][]cfclient.UserRole),
+	}
+	var mu sync.Mutex
+	keys := sortedTargetKeys(desired)
+	// List the users of every role, and of every org
+	orgs := make(map[string]string)
+	for _, d := range desired {
+		orgs[d.target.OrgGuid] = d.target.OrgName
+	}
+	var orgGuids []string
+	for orgGuid := range orgs {
+		orgGuids = append(orgGuids, orgGuid)
+	}
+	forEachConcurrently(len(keys), groupConcurrency, func(i int) {
+		target := desired[keys[i]].target
+		var users []cfclient.User
+		var err error
+		if target.SpaceGuid != "" {
+			users, err = cf.ListSpaceRoleUsers(target.SpaceGuid, target.CfRole)
+		} else {
+			users, err = cf.ListOrgRoleUsers(target.OrgGuid, target.CfRole)
+		}
+		if err != nil {
+			log.Printf("Could not get list of existing role members of '%v' from CF: %v\n", describeTarget(target), err)
+			summary.count(&summary.Errors)
+			return
+		}
+		mu.Lock()
+		actual.roleUsers[keys[i]] = indexUsers(users)
+		mu.Unlock()
+	})
+	forEachConcurrently(len(orgGuids), groupConcurrency, func(i int) {
+		users, err := cf.ListOrgRoleUsers(orgGuids[i], cfclient.OrgUser)
+		if err != nil {
+			log.Printf("Could not get list of users of org '%v' from CF: %v\n", orgs[orgGuids[i]], err)
+			summary.count(&summary.Errors)
+			return
+		}
+		mu.Lock()
+		actual.orgUsers[orgGuids[i]] = indexUsers(users)
+		mu.Unlock()
+	})
+	// Find the GUIDs of the desired users. Most users are already known from the lists of role users
+	var lookups []string
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		for username := range desired[key].members {
+			if seen[username] {
+				continue
+			}
+			seen[username] = true
+			if guid := actual.knownGuid(username); guid != "" {
+				actual.userGuids[username] = guid
+				continue
+			}
+			lookups = append(lookups, username)
+		}
+	}
+	forEachConcurrently(len(lookups), memberConcurrency, func(i int) {
+		username := lookups[i]
+		user, err := cf.FindUAAUser(username)
+		mu.Lock()
+		defer mu.Unlock()
+		if err == cfclient.ErrNotFound {
+			actual.missingUsers[username] = true
+			return
+		}
+		if err != nil {
+			log.Printf("Could not search user '%v' in UAA: %v\n", username, err)
+			summary.count(&summary.Errors)
+			return
+		}
+		actual.userGuids[username] = user.ID
+	})
+	// List all roles of the users who lose a role, to find out if they can be removed from the org
+	var revoked []string
+	seen = make(map[string]bool)
+	for _, key := range keys {
+		for _, user := range revokedUsers(desired[key], actual.roleUsers[key]) {
+			if !seen[user.GUID] {
+				seen[user.GUID] = true
+				revoked = append(revoked, user.GUID)
+			}
+		}
+	}
+	forEachConcurrently(len(revoked), memberConcurrency, func(i int) {
+		roles, err := cf.ListUserRoles(revoked[i])
+		if err != nil {
+			log.Printf("Could not get the roles of user '%v' from CF: %v\n", revoked[i], err)
+			summary.count(&summary.Errors)
+			return
+		}
+		mu.Lock()
+		actual.userRoles[revoked[i]] = roles
+		mu.Unlock()
+	})
+	return actual
+}
+
+// Returns the GUID of the user from the lists of role users and org users, or "" when the user is not in any list
+func (a *actualState) knownGuid(username string) string {
+	for _, users := range a.roleUsers {
+		if user, ok := users[username]; ok {
+			return user.GUID
+		}
+	}
+	for _, users := range a.orgUsers {
+		if user, ok := users[username]; ok {
+			return user.GUID
+		}
+	}
+	return ""
+}
+
+// Returns the number of users with the role, by role target key. Roles whose users could not be listed are left out
+func (a *actualState) roleHolders() map[string]int {
+	holders := make(map[string]int)
+	for key, users := range a.roleUsers {
+		if users != nil {
+			holders[key] = len(users)
+		}
+	}
+	return holders
+}
+
+// Returns the users who have the role, but should not have it anymore
+// Only users which were created as SSO user are taken into account
+// Nothing is revoked when the role is only assigned by the app, or when the group members or role users are not completely known
+func revokedUsers(d *desiredTarget, roleUsers map[string]cfclient.User) []cfclient.User {
+	if !d.revoke || !d.complete || roleUsers == nil {
+		return nil
+	}
+	var users []cfclient.User
+	for username, user := range roleUsers {
+		if user.Origin != os.Getenv(token.EnvUaaSsoProvider) {
+			continue
+		}
+		if _, ok := d.members[username]; !ok {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// Returns the users by lowercase username
+func indexUsers(users []cfclient.User) map[string]cfclient.User {
+	index := make(map[string]cfclient.User)
+	for _, user := range users {
+		index[strings.ToLower(user.Username)] = user
+	}
+	return index
+}
+
+// Returns a readable name of the role target for logging, e.g. "spacedeveloper in engineering-enablement/live"
+func describeTarget(target roleTarget) string {
+	if target.SpaceName != "" {
+		return target.Role + " in " + target.OrgName + "/" + target.SpaceName
+	}
+	return target.Role + " in " + target.OrgName
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Declaration of environment variable key names
+const EnvAliasFile string = "ALIASFILE"
+
+// Maps the org and space names used in group names to the exact CF org and space names
+// Needed for CF names which can't be part of an email address, e.g. names with uppercase letters or spaces
+type aliasTable struct {
+	// CF org name by alias
+	Orgs map[string]string `yaml:"orgs"`
+	// CF space name by alias, by org alias
+	Spaces map[string]map[string]string `yaml:"spaces"`
+}
+
+// The alias table which is used for resolving the org and space names of groups
+// Loaded by loadAliasTable at startup. Empty when no alias file is configured
+var aliases = &aliasTable{}
+
+// Loads the alias table from the file in the ALIASFILE environment variable (YAML or JSON)
+func loadAliasTable() error {
+	if os.Getenv(EnvAliasFile) == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(os.Getenv(EnvAliasFile))
+	if err != nil {
+		return err
+	}
+	var table aliasTable
+	if err := yaml.UnmarshalStrict(b, &table); err != nil {
+		return errors.New("Could not parse alias file '" + os.Getenv(EnvAliasFile) + "': " + err.Error())
+	}
+	// The aliases are matched case-insensitively
+	aliases = &aliasTable{
+		Orgs:   make(map[string]string),
+		Spaces: make(map[string]map[string]string),
+	}
+	for alias, name := range table.Orgs {
+		aliases.Orgs[strings.ToLower(alias)] = name
+	}
+	for orgAlias, spaces := range table.Spaces {
+		aliases.Spaces[strings.ToLower(orgAlias)] = make(map[string]string)
+		for alias, name := range spaces {
+			aliases.Spaces[strings.ToLower(orgAlias)][strings.ToLower(alias)] = name
+		}
+	}
+	return nil
+}
+
+// Replaces the org and space of the group with the CF names, when they are an alias
+func (a *aliasTable) resolve(group *Group) {
+	orgAlias := strings.ToLower(group.Org)
+	if group.Space != "" {
+		if name, ok := a.Spaces[orgAlias][strings.ToLower(group.Space)]; ok {
+			log.Println("Space alias '" + group.Space + "' in org '" + group.Org + "' resolves to space '" + name + "'")
+			group.Space = name
+		}
+	}
+	if name, ok := a.Orgs[orgAlias]; ok {
+		log.Println("Org alias '" + group.Org + "' resolves to org '" + name + "'")
+		group.Org = name
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/SpringerPE/cf-user-role-syncher/cfclient"
+)
+
+// Applies the operations of the plan, one action after the other
+// The operations of an action are applied concurrently. Operations which depend on a failed operation
+// (e.g. assigning a role to a user who could not be created) are skipped
+// Stops before the next action when stop is closed, or when this instance is not the leader anymore
+func applyPlan(plan *syncPlan, stop <-chan struct{}, elector leaderElector, summary *cycleSummary) {
+	var mu sync.Mutex
+	// GUIDs of the users created by the plan, by lowercase username
+	createdGuids := make(map[string]string)
+	// Failed operations, by action, user and org GUID
+	failed := make(map[string]bool)
+	counters := map[string]*int{
+		ActionCreateUser:    &summary.Created,
+		ActionAssociate:     &summary.Associated,
+		ActionAssign:        &summary.Assigned,
+		ActionUnassign:      &summary.Unset,
+		ActionRemoveFromOrg: &summary.RemovedFromOrg,
+	}
+	for _, action := range planActions {
+		ops := plan.opsFor(action)
+		if len(ops) == 0 {
+			continue
+		}
+		if !shouldContinue(stop, elector) {
+			summary.Interrupted = true
+			return
+		}
+		forEachConcurrently(len(ops), memberConcurrency, func(i int) {
+			op := ops[i]
+			// Operations which were not started yet are skipped when the app is stopped
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			mu.Lock()
+			if op.userGuid == "" {
+				op.userGuid = createdGuids[op.User]
+			}
+			skip := failed[ActionAssociate+"/"+op.orgGuid+"/"+op.User] || failed[ActionUnassign+"/"+op.orgGuid+"/"+op.User]
+			mu.Unlock()
+			if (op.Action != ActionCreateUser && op.userGuid == "") || skip {
+				log.Println("Skipping: " + op.describe())
+				return
+			}
+			guid, err := applyOp(op)
+			if err != nil {
+				log.Printf("Could not %v: %v\n", op.describe(), err)
+				summary.count(&summary.Errors)
+				mu.Lock()
+				failed[op.Action+"/"+op.orgGuid+"/"+op.User] = true
+				mu.Unlock()
+				return
+			}
+			log.Println("Successfully applied: " + op.describe())
+			summary.count(counters[op.Action])
+			if op.Action == ActionCreateUser {
+				mu.Lock()
+				createdGuids[op.User] = guid
+				mu.Unlock()
+			}
+		})
+	} // End for (actions)
+	select {
+	case <-stop:
+		summary.Interrupted = true
+	default:
+	}
+}
+
+// Applies a single operation of the plan
+// Returns the GUID of the user for create-user
+func applyOp(op planOp) (string, error) {
+	switch op.Action {
+	case ActionCreateUser:
+		return createShadowUserCF(op.User)
+	case ActionAssociate:
+		return "", cf.AssignOrgRole(op.orgGuid, cfclient.OrgUser, op.userGuid)
+	case ActionAssign:
+		if op.spaceGuid != "" {
+			return "", cf.AssignSpaceRole(op.spaceGuid, op.cfRole, op.userGuid)
+		}
+		return "", cf.AssignOrgRole(op.orgGuid, op.cfRole, op.userGuid)
+	case ActionUnassign:
+		if op.spaceGuid != "" {
+			return "", cf.RemoveSpaceRole(op.spaceGuid, op.cfRole, op.userGuid)
+		}
+		return "", cf.RemoveOrgRole(op.orgGuid, op.cfRole, op.userGuid)
+	case ActionRemoveFromOrg:
+		return "", cf.RemoveOrgRole(op.orgGuid, cfclient.OrgUser, op.userGuid)
+	}
+	return "", errors.New("Unknown action '" + op.Action + "'")
+}
@@ -0,0 +1,218 @@
+// Package cfclient is a small client for the Cloud Foundry Cloud Controller API (v2 and v3) and UAA
+// It only covers what is needed for managing org and space roles of users
+package cfclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Returned when a search for a single resource (e.g. an org by name) found nothing
+var ErrNotFound = errors.New("not found")
+
+// Returned for every request which would change something while the Client is read only
+var ErrReadOnly = errors.New("client is read only")
+
+// Returned for every HTTP response with an unexpected status code
+type HttpError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	// The response body, which mostly holds the error details of CF or UAA
+	Body string
+}
+
+func (e *HttpError) Error() string {
+	return e.Method + " " + e.URL + " failed with HTTP status code " + strconv.Itoa(e.StatusCode) + ": " + e.Body
+}
+
+// Client for the CF API and UAA of a single foundation
+type Client struct {
+	// e.g. https://api.mycfdomain.org
+	ApiEndpoint string
+	// e.g. https://uaa.mycfdomain.org
+	UaaEndpoint string
+	// The CF API version which is used: "v2" or "v3"
+	ApiVersion string
+	// Number of results per page for list calls
+	PerPage int
+	// Returns a new Oauth Access Token (e.g. "bearer xyz"), used when CF or UAA report the token has expired
+	GetToken   func() (string, error)
+	HttpClient *http.Client
+	// When set, only GET requests are sent. All other requests fail with ErrReadOnly
+	ReadOnly bool
+	// Initializing this with a value similar to 'bearer something' is important
+	// This will make CF recognize the Access Token is invalid with the first request to CF
+	accessToken string
+	// Guards accessToken, as the Client can be used by several goroutines at the same time
+	tokenMu sync.Mutex
+}
+
+// Creates a new Client for the CF API v2
+func New(apiEndpoint string, uaaEndpoint string, getToken func() (string, error)) *Client {
+	return &Client{
+		ApiEndpoint: strings.TrimSuffix(apiEndpoint, "/"),
+		UaaEndpoint: strings.TrimSuffix(uaaEndpoint, "/"),
+		ApiVersion:  "v2",
+		PerPage:     100,
+		GetToken:    getToken,
+		HttpClient:  &http.Client{},
+		accessToken: "bearer none",
+	}
+}
+
+// Sends a request with a json payload (when not nil) and parses the json response into result (when not nil)
+// Returns an HttpError when the response status code is not one of the expected codes
+func (c *Client) do(method string, requestUrl string, querystring url.Values, payload interface{}, result interface{}, expected ...int) error {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return err
+		}
+	}
+	resp, err := c.send(method, requestUrl, querystring, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ok := false
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			ok = true
+		}
+	}
+	if !ok {
+		bodyBytes, _ := ioutil.ReadAll(resp.Body)
+		return &HttpError{Method: method, URL: requestUrl, StatusCode: resp.StatusCode, Body: string(bodyBytes)}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// Sends the HTTP request with the Oauth Access Token
+// When the Access Token has expired, a new one is requested and the request is retried once
+// An error is only returned when no response was received. The caller must close the body of the response
+func (c *Client) send(method string, requestUrl string, querystring url.Values, payload []byte) (*http.Response, error) {
+	if c.ReadOnly && method != "GET" {
+		// Returned as it is, so callers can compare it with ErrReadOnly
+		return nil, ErrReadOnly
+	}
+	// Create new http request
+	req, err := http.NewRequest(method, requestUrl, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	// Check if any query string parameters are supplied. If yes, add them to the request
+	if querystring != nil {
+		req.URL.RawQuery = querystring.Encode()
+	}
+	// Set Headers
+	accessToken := c.getAccessToken()
+	req.Header.Add("Authorization", accessToken)
+	if payload != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	// Execute request
+	resp, err := c.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	// The Oauth AccessToken could be expired. If so, we do one try to get a new one and retry the request
+	if resp.StatusCode == 401 {
+		log.Println("Received HTTP 401 response.")
+		// CF API v2 returns the error_code, CF API v3 returns a list of errors with a title
+		type ErrorCode struct {
+			ErrorCode string `json:"error_code"`
+			Errors    []struct {
+				Title string `json:"title"`
+			} `json:"errors"`
+			// UAA returns an OAuth error
+			Error string `json:"error"`
+		}
+		var errorCode ErrorCode
+		// Try to read the error code from the response body
+		bodyBytes, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(bodyBytes))
+		if err := json.Unmarshal(bodyBytes, &errorCode); err != nil {
+			log.Printf("Error while reading error_code: %v\n", err)
+		} else {
+			if len(errorCode.Errors) > 0 {
+				errorCode.ErrorCode = errorCode.Errors[0].Title
+			}
+			if errorCode.ErrorCode == "CF-InvalidAuthToken" || errorCode.Error == "invalid_token" {
+				// Get new AccessToken
+				accessToken, err := c.refreshAccessToken(accessToken)
+				if err != nil {
+					return nil, errors.New("Failed getting a new CF Access Token: " + err.Error())
+				}
+				// Reset the Authorization header and the payload
+				req.Header.Set("Authorization", accessToken)
+				req.Body = ioutil.NopCloser(bytes.NewReader(payload))
+				// Retry the original request
+				resp, err = c.HttpClient.Do(req)
+				if err != nil {
+					return nil, errors.New("Error while retrying HTTP request with new CF Access Token: " + err.Error())
+				}
+				if resp.StatusCode == 401 {
+					log.Println("Retrying the original HTTP request with new Access Token still results in HTTP 401.")
+				}
+			} // End if (errorCode check)
+		} // End if (Successful response body parsing)
+	} // End if (StatusCode = 401)
+	// In case the response is not HTTP 2xx (success), we would like to know what
+	// is in the response body. (Most likely some error which could be helpful)
+	if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
+		// Convert response body into a string, and keep it available for the caller
+		bodyBytes, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(bodyBytes))
+		if err != nil {
+			log.Println("Not able to output the error for unsuccessful HTTP request (no 2xx code)")
+		} else {
+			// Log multi line string
+			log.Println("Error while sending HTTP request:\n" +
+				"HTTP status code: " + strconv.Itoa(resp.StatusCode) + "\n" +
+				"HTTP response body:" + string(bodyBytes))
+		}
+	} // End if (when http response is not 2xx)
+	// All done processing the http request. Return the response instance
+	return resp, nil
+}
+
+// Returns the current Oauth Access Token
+func (c *Client) getAccessToken() string {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	return c.accessToken
+}
+
+// Gets a new Oauth Access Token, because the expired token was rejected
+// When several requests are rejected at the same time, only the first one gets a new token.
+// The others wait for it and use the same new token
+func (c *Client) refreshAccessToken(expired string) (string, error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	if c.accessToken != expired {
+		// Another request already got a new token
+		return c.accessToken, nil
+	}
+	log.Println("CF OAuth Access Token has expired. Will try to get new Access Token.")
+	accessToken, err := c.GetToken()
+	if err != nil {
+		return "", err
+	}
+	c.accessToken = accessToken
+	return accessToken, nil
+}
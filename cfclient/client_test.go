@@ -0,0 +1,135 @@
+package cfclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Starts a server with the handler and returns a Client for it, which uses the server for both the CF API and UAA
+// GetToken returns "bearer new". The returned function stops the server
+func newTestClient(handler http.HandlerFunc) (*Client, func()) {
+	server := httptest.NewServer(handler)
+	c := New(server.URL, server.URL, func() (string, error) { return "bearer new", nil })
+	return c, server.Close
+}
+
+func TestExpiredAccessToken(t *testing.T) {
+	tests := []struct {
+		name string
+		// The response body of the 401 for the expired token
+		body string
+		// Set when a new token is requested and the request retried
+		refreshed bool
+	}{
+		{name: "CF API v2", body: `{"error_code": "CF-InvalidAuthToken"}`, refreshed: true},
+		{name: "CF API v3", body: `{"errors": [{"title": "CF-InvalidAuthToken"}]}`, refreshed: true},
+		{name: "UAA", body: `{"error": "invalid_token"}`, refreshed: true},
+		// Other reasons for a 401 can't be fixed with a new token
+		{name: "other error", body: `{"error_code": "CF-NotAuthenticated"}`},
+		{name: "no json", body: `unauthorized`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests int
+			var payloads []string
+			c, stop := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				body, _ := ioutil.ReadAll(r.Body)
+				payloads = append(payloads, string(body))
+				if r.Header.Get("Authorization") != "bearer new" {
+					w.WriteHeader(401)
+					w.Write([]byte(test.body))
+					return
+				}
+				w.WriteHeader(201)
+			})
+			defer stop()
+			tokens := 0
+			c.GetToken = func() (string, error) {
+				tokens++
+				return "bearer new", nil
+			}
+			err := c.CreateUser("guid-alice")
+			if !test.refreshed {
+				if httpErr, ok := err.(*HttpError); !ok || httpErr.StatusCode != 401 || tokens != 0 || requests != 1 {
+					t.Errorf("expected a single request failing with HTTP 401, got %v after %d requests and %d tokens", err, requests, tokens)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tokens != 1 || requests != 2 {
+				t.Errorf("expected 1 new token and 2 requests, got %d and %d", tokens, requests)
+			}
+			// The payload is sent again with the retried request
+			if payloads[1] != payloads[0] || payloads[1] != `{"guid":"guid-alice"}` {
+				t.Errorf("expected the payload to be sent twice, got %q", payloads)
+			}
+			// The new token is kept for the next requests
+			requests = 0
+			if err := c.CreateUser("guid-bob"); err != nil || requests != 1 || tokens != 1 {
+				t.Errorf("expected the new token to be used, got %v after %d requests and %d tokens", err, requests, tokens)
+			}
+		})
+	}
+}
+
+func TestExpiredAccessTokenRefreshedOnce(t *testing.T) {
+	// The new token is rejected as well
+	c, stop := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(401)
+		w.Write([]byte(`{"error_code": "CF-InvalidAuthToken"}`))
+	})
+	defer stop()
+	tokens := 0
+	c.GetToken = func() (string, error) {
+		tokens++
+		return "bearer new", nil
+	}
+	err := c.CreateUser("guid-alice")
+	if httpErr, ok := err.(*HttpError); !ok || httpErr.StatusCode != 401 || tokens != 1 {
+		t.Errorf("expected HTTP 401 after a single new token, got %v after %d tokens", err, tokens)
+	}
+}
+
+func TestHttpError(t *testing.T) {
+	c, stop := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+		w.Write([]byte(`{"description": "unavailable"}`))
+	})
+	defer stop()
+	c.ApiVersion = "v3"
+	err := c.AssignOrgRole("org-1", OrgManager, "guid-alice")
+	httpErr, ok := err.(*HttpError)
+	if !ok {
+		t.Fatalf("expected an HttpError, got %v", err)
+	}
+	expected := HttpError{Method: "GET", URL: c.ApiEndpoint + "/v3/roles", StatusCode: 500, Body: `{"description": "unavailable"}`}
+	if *httpErr != expected {
+		t.Errorf("expected %+v, got %+v", expected, *httpErr)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	var methods []string
+	c, stop := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		w.Write([]byte(`{"pagination": {"next": null}, "resources": []}`))
+	})
+	defer stop()
+	c.ApiVersion = "v3"
+	c.ReadOnly = true
+	// The role is searched first, but not created
+	if err := c.AssignOrgRole("org-1", OrgManager, "guid-alice"); err != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+	if _, err := c.CreateUAAUser("alice@example.com", "sso"); err != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+	if len(methods) != 1 || methods[0] != "GET" {
+		t.Errorf("expected only a GET request, got %v", methods)
+	}
+}
@@ -0,0 +1,122 @@
+package cfclient
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+)
+
+// Structure for a single page of a CF API v2 list endpoint
+type v2Page struct {
+	NextURL   string            `json:"next_url"`
+	Resources []json.RawMessage `json:"resources"`
+}
+
+// Structure for a single page of a CF API v3 list endpoint
+type v3Page struct {
+	Pagination struct {
+		Next *struct {
+			Href string `json:"href"`
+		} `json:"next"`
+	} `json:"pagination"`
+	Resources []json.RawMessage            `json:"resources"`
+	Included  map[string][]json.RawMessage `json:"included"`
+}
+
+// Structure for the metadata of a CF API v2 resource
+type v2Metadata struct {
+	GUID string `json:"guid"`
+}
+
+// Structure for a relationship to another resource in CF (API v3)
+type v3Relationship struct {
+	Data *struct {
+		GUID string `json:"guid"`
+	} `json:"data"`
+}
+
+// Returns the GUID of the related resource, or an empty string when there is none
+func (r v3Relationship) guid() string {
+	if r.Data == nil {
+		return ""
+	}
+	return r.Data.GUID
+}
+
+// Sends GET requests to a CF API v2 list endpoint (e.g. /v2/organizations) and follows next_url until all pages are read
+// The resources of all pages are returned
+func (c *Client) listV2(path string, querystring url.Values) ([]json.RawMessage, error) {
+	// Copy the query string parameters, so the caller's values are not changed
+	q := url.Values{}
+	for key, values := range querystring {
+		q[key] = values
+	}
+	q.Set("results-per-page", strconv.Itoa(c.PerPage))
+	var resources []json.RawMessage
+	requestUrl := c.ApiEndpoint + path
+	for requestUrl != "" {
+		var page v2Page
+		if err := c.do("GET", requestUrl, q, nil, &page, 200); err != nil {
+			return nil, err
+		}
+		resources = append(resources, page.Resources...)
+		// The next_url already contains all query string parameters
+		requestUrl = ""
+		if page.NextURL != "" {
+			nextUrl, err := url.Parse(page.NextURL)
+			if err != nil {
+				return nil, err
+			}
+			requestUrl = c.ApiEndpoint + nextUrl.Path
+			q = nextUrl.Query()
+		}
+	}
+	return resources, nil
+}
+
+// Sends GET requests to a CF API v3 list endpoint (e.g. /v3/roles) and follows pagination.next until all pages are read
+// The resources and the included resources (by type, e.g. "users") of all pages are returned
+func (c *Client) listV3(path string, querystring url.Values) ([]json.RawMessage, map[string][]json.RawMessage, error) {
+	// Copy the query string parameters, so the caller's values are not changed
+	q := url.Values{}
+	for key, values := range querystring {
+		q[key] = values
+	}
+	q.Set("per_page", strconv.Itoa(c.PerPage))
+	var resources []json.RawMessage
+	included := make(map[string][]json.RawMessage)
+	requestUrl := c.ApiEndpoint + path
+	for requestUrl != "" {
+		var page v3Page
+		if err := c.do("GET", requestUrl, q, nil, &page, 200); err != nil {
+			return nil, nil, err
+		}
+		resources = append(resources, page.Resources...)
+		for key, items := range page.Included {
+			included[key] = append(included[key], items...)
+		}
+		// The next href already contains all query string parameters
+		requestUrl = ""
+		if page.Pagination.Next != nil && page.Pagination.Next.Href != "" {
+			nextUrl, err := url.Parse(page.Pagination.Next.Href)
+			if err != nil {
+				return nil, nil, err
+			}
+			requestUrl = c.ApiEndpoint + nextUrl.Path
+			q = nextUrl.Query()
+		}
+	}
+	return resources, included, nil
+}
+
+// Parses the raw json items into result, which must be a pointer to a slice
+func unmarshalAll(items []json.RawMessage, result interface{}) error {
+	if items == nil {
+		items = []json.RawMessage{}
+	}
+	b, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, result)
+}
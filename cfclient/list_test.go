@@ -0,0 +1,77 @@
+package cfclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestListV2FollowsPages(t *testing.T) {
+	var queries []string
+	c, stop := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query().Encode())
+		page := map[string]interface{}{"resources": []map[string]string{{"name": "org-" + r.URL.Query().Get("page")}}}
+		if r.URL.Query().Get("page") == "" {
+			page["resources"] = []map[string]string{{"name": "org-1"}}
+			page["next_url"] = "/v2/organizations?page=2&q=name%3Aorg&results-per-page=2"
+		}
+		json.NewEncoder(w).Encode(page)
+	})
+	defer stop()
+	c.PerPage = 2
+	querystring := url.Values{"q": {"name:org"}}
+	items, err := c.listV2("/v2/organizations", querystring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || !strings.Contains(string(items[0]), "org-1") || !strings.Contains(string(items[1]), "org-2") {
+		t.Errorf("expected the resources of both pages, got %s", items)
+	}
+	expected := []string{"q=name%3Aorg&results-per-page=2", "page=2&q=name%3Aorg&results-per-page=2"}
+	if strings.Join(queries, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected the queries %v, got %v", expected, queries)
+	}
+	// The query string of the caller is not changed
+	if len(querystring) != 1 {
+		t.Errorf("expected the query string to be unchanged, got %v", querystring)
+	}
+}
+
+func TestListV3FollowsPages(t *testing.T) {
+	var queries []string
+	var server string
+	c, stop := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query().Encode())
+		page := map[string]interface{}{
+			"pagination": map[string]interface{}{"next": nil},
+			"resources":  []map[string]string{{"guid": "role-2"}},
+			"included":   map[string][]map[string]string{"users": {{"guid": "guid-bob"}}},
+		}
+		if r.URL.Query().Get("page") == "" {
+			// CF returns absolute URLs
+			page["pagination"] = map[string]interface{}{"next": map[string]string{"href": server + "/v3/roles?include=user&page=2&per_page=1"}}
+			page["resources"] = []map[string]string{{"guid": "role-1"}}
+			page["included"] = map[string][]map[string]string{"users": {{"guid": "guid-alice"}}}
+		}
+		json.NewEncoder(w).Encode(page)
+	})
+	defer stop()
+	server = c.ApiEndpoint
+	c.PerPage = 1
+	items, included, err := c.listV3("/v3/roles", url.Values{"include": {"user"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || !strings.Contains(string(items[1]), "role-2") {
+		t.Errorf("expected the resources of both pages, got %s", items)
+	}
+	if len(included["users"]) != 2 || !strings.Contains(string(included["users"][1]), "guid-bob") {
+		t.Errorf("expected the included users of both pages, got %s", included["users"])
+	}
+	expected := []string{"include=user&per_page=1", "include=user&page=2&per_page=1"}
+	if strings.Join(queries, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected the queries %v, got %v", expected, queries)
+	}
+}
@@ -0,0 +1,156 @@
+package cfclient
+
+import (
+	"errors"
+	"net/url"
+)
+
+// A CF org
+type Org struct {
+	GUID string
+	Name string
+}
+
+// A CF space
+type Space struct {
+	GUID    string
+	Name    string
+	OrgGUID string
+}
+
+// Structure for an org or space of the CF API v2
+type v2NamedResource struct {
+	Metadata v2Metadata `json:"metadata"`
+	Entity   struct {
+		Name             string `json:"name"`
+		OrganizationGUID string `json:"organization_guid"`
+	} `json:"entity"`
+}
+
+// Structure for an org or space of the CF API v3
+type v3NamedResource struct {
+	GUID          string `json:"guid"`
+	Name          string `json:"name"`
+	Relationships struct {
+		Organization v3Relationship `json:"organization"`
+	} `json:"relationships"`
+}
+
+// Returns the org with exactly this name
+// Returns ErrNotFound when there is no such org
+func (c *Client) GetOrgByName(name string) (*Org, error) {
+	orgs, err := c.listOrgs(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(orgs) == 0 {
+		return nil, ErrNotFound
+	}
+	if len(orgs) > 1 {
+		return nil, errors.New("Search for org '" + name + "' did not result in exactly 1 match!")
+	}
+	return &orgs[0], nil
+}
+
+// Returns all orgs
+func (c *Client) ListOrgs() ([]Org, error) {
+	return c.listOrgs("")
+}
+
+// Returns the orgs, filtered by name when it is not empty
+func (c *Client) listOrgs(name string) ([]Org, error) {
+	var orgs []Org
+	q := url.Values{}
+	if c.ApiVersion == "v3" {
+		if name != "" {
+			q.Add("names", name)
+		}
+		items, _, err := c.listV3("/v3/organizations", q)
+		if err != nil {
+			return nil, err
+		}
+		var resources []v3NamedResource
+		if err := unmarshalAll(items, &resources); err != nil {
+			return nil, err
+		}
+		for _, r := range resources {
+			orgs = append(orgs, Org{GUID: r.GUID, Name: r.Name})
+		}
+		return orgs, nil
+	}
+	if name != "" {
+		q.Add("q", "name:"+name)
+	}
+	items, err := c.listV2("/v2/organizations", q)
+	if err != nil {
+		return nil, err
+	}
+	var resources []v2NamedResource
+	if err := unmarshalAll(items, &resources); err != nil {
+		return nil, err
+	}
+	for _, r := range resources {
+		orgs = append(orgs, Org{GUID: r.Metadata.GUID, Name: r.Entity.Name})
+	}
+	return orgs, nil
+}
+
+// Returns the space with exactly this name in the org
+// Returns ErrNotFound when there is no such space
+func (c *Client) GetSpace(orgGuid string, name string) (*Space, error) {
+	spaces, err := c.listSpaces(orgGuid, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(spaces) == 0 {
+		return nil, ErrNotFound
+	}
+	if len(spaces) > 1 {
+		return nil, errors.New("Search for space '" + name + "' did not result in exactly 1 match!")
+	}
+	return &spaces[0], nil
+}
+
+// Returns all spaces of the org
+func (c *Client) ListSpaces(orgGuid string) ([]Space, error) {
+	return c.listSpaces(orgGuid, "")
+}
+
+// Returns the spaces of the org, filtered by name when it is not empty
+func (c *Client) listSpaces(orgGuid string, name string) ([]Space, error) {
+	var spaces []Space
+	q := url.Values{}
+	if c.ApiVersion == "v3" {
+		q.Add("organization_guids", orgGuid)
+		if name != "" {
+			q.Add("names", name)
+		}
+		items, _, err := c.listV3("/v3/spaces", q)
+		if err != nil {
+			return nil, err
+		}
+		var resources []v3NamedResource
+		if err := unmarshalAll(items, &resources); err != nil {
+			return nil, err
+		}
+		for _, r := range resources {
+			spaces = append(spaces, Space{GUID: r.GUID, Name: r.Name, OrgGUID: r.Relationships.Organization.guid()})
+		}
+		return spaces, nil
+	}
+	if name != "" {
+		q.Add("q", "name:"+name)
+	}
+	items, err := c.listV2("/v2/organizations/"+url.PathEscape(orgGuid)+"/spaces", q)
+	if err != nil {
+		return nil, err
+	}
+	var resources []v2NamedResource
+	if err := unmarshalAll(items, &resources); err != nil {
+		return nil, err
+	}
+	for _, r := range resources {
+		spaces = append(spaces, Space{GUID: r.Metadata.GUID, Name: r.Entity.Name, OrgGUID: r.Entity.OrganizationGUID})
+	}
+	return spaces, nil
+}
@@ -0,0 +1,386 @@
+package cfclient
+
+import (
+	"errors"
+	"net/url"
+)
+
+// A CF org or space role type, named as in the CF API v3
+type Role string
+
+// Org roles
+const (
+	// Associates the user with the org. Needed before the user can get any space role in the org
+	OrgUser           Role = "organization_user"
+	OrgManager        Role = "organization_manager"
+	OrgBillingManager Role = "organization_billing_manager"
+	OrgAuditor        Role = "organization_auditor"
+)
+
+// Space roles
+const (
+	SpaceManager   Role = "space_manager"
+	SpaceDeveloper Role = "space_developer"
+	SpaceAuditor   Role = "space_auditor"
+)
+
+// CF API v2 resource paths of the org roles
+var v2OrgRolePaths = map[Role]string{
+	OrgUser:           "/users",
+	OrgManager:        "/managers",
+	OrgBillingManager: "/billing_managers",
+	OrgAuditor:        "/auditors",
+}
+
+// CF API v2 resource paths of the space roles
+var v2SpaceRolePaths = map[Role]string{
+	SpaceManager:   "/managers",
+	SpaceDeveloper: "/developers",
+	SpaceAuditor:   "/auditors",
+}
+
+// A CF user
+type User struct {
+	GUID     string
+	Username string
+	// The UAA origin of the user, e.g. "uaa" or the name of an SSO provider
+	Origin string
+}
+
+// A role of a user
+type UserRole struct {
+	Type Role
+	// For space roles this is the org of the space
+	OrgGUID string
+	// Empty for org roles
+	SpaceGUID string
+}
+
+// Structure for a user of the CF API v2
+type v2User struct {
+	Metadata v2Metadata `json:"metadata"`
+	Entity   struct {
+		Username string `json:"username"`
+	} `json:"entity"`
+}
+
+// Structure for a role of the CF API v3
+type v3Role struct {
+	GUID          string `json:"guid"`
+	Type          Role   `json:"type"`
+	Relationships struct {
+		User         v3Relationship `json:"user"`
+		Organization v3Relationship `json:"organization"`
+		Space        v3Relationship `json:"space"`
+	} `json:"relationships"`
+}
+
+// Structure for a user of the CF API v3
+type v3User struct {
+	GUID     string `json:"guid"`
+	Username string `json:"username"`
+	Origin   string `json:"origin"`
+}
+
+// Assigns the org role to the user
+func (c *Client) AssignOrgRole(orgGuid string, role Role, userGuid string) error {
+	path, ok := v2OrgRolePaths[role]
+	if !ok {
+		return errors.New("'" + string(role) + "' is not an org role")
+	}
+	if c.ApiVersion == "v3" {
+		return c.assignRoleV3(role, userGuid, "organization", orgGuid)
+	}
+	return c.do("PUT", c.ApiEndpoint+"/v2/organizations/"+url.PathEscape(orgGuid)+path+"/"+url.PathEscape(userGuid), nil, nil, nil, 201)
+}
+
+// Assigns the space role to the user
+// The user must already be associated with the org of the space (OrgUser)
+func (c *Client) AssignSpaceRole(spaceGuid string, role Role, userGuid string) error {
+	path, ok := v2SpaceRolePaths[role]
+	if !ok {
+		return errors.New("'" + string(role) + "' is not a space role")
+	}
+	if c.ApiVersion == "v3" {
+		return c.assignRoleV3(role, userGuid, "space", spaceGuid)
+	}
+	return c.do("PUT", c.ApiEndpoint+"/v2/spaces/"+url.PathEscape(spaceGuid)+path+"/"+url.PathEscape(userGuid), nil, nil, nil, 201)
+}
+
+// Removes the org role from the user
+// Removing OrgUser fails when the user still has other roles in the org
+func (c *Client) RemoveOrgRole(orgGuid string, role Role, userGuid string) error {
+	path, ok := v2OrgRolePaths[role]
+	if !ok {
+		return errors.New("'" + string(role) + "' is not an org role")
+	}
+	if c.ApiVersion == "v3" {
+		return c.removeRoleV3(role, userGuid, "organization", orgGuid)
+	}
+	return c.do("DELETE", c.ApiEndpoint+"/v2/organizations/"+url.PathEscape(orgGuid)+path+"/"+url.PathEscape(userGuid), nil, nil, nil, 204)
+}
+
+// Removes the space role from the user
+func (c *Client) RemoveSpaceRole(spaceGuid string, role Role, userGuid string) error {
+	path, ok := v2SpaceRolePaths[role]
+	if !ok {
+		return errors.New("'" + string(role) + "' is not a space role")
+	}
+	if c.ApiVersion == "v3" {
+		return c.removeRoleV3(role, userGuid, "space", spaceGuid)
+	}
+	return c.do("DELETE", c.ApiEndpoint+"/v2/spaces/"+url.PathEscape(spaceGuid)+path+"/"+url.PathEscape(userGuid), nil, nil, nil, 204)
+}
+
+// Returns the users which have the org role
+func (c *Client) ListOrgRoleUsers(orgGuid string, role Role) ([]User, error) {
+	path, ok := v2OrgRolePaths[role]
+	if !ok {
+		return nil, errors.New("'" + string(role) + "' is not an org role")
+	}
+	if c.ApiVersion == "v3" {
+		return c.listRoleUsersV3(role, "organization", orgGuid)
+	}
+	return c.listRoleUsersV2("/v2/organizations/" + url.PathEscape(orgGuid) + path)
+}
+
+// Returns the users which have the space role
+func (c *Client) ListSpaceRoleUsers(spaceGuid string, role Role) ([]User, error) {
+	path, ok := v2SpaceRolePaths[role]
+	if !ok {
+		return nil, errors.New("'" + string(role) + "' is not a space role")
+	}
+	if c.ApiVersion == "v3" {
+		return c.listRoleUsersV3(role, "space", spaceGuid)
+	}
+	return c.listRoleUsersV2("/v2/spaces/" + url.PathEscape(spaceGuid) + path)
+}
+
+// Returns all org and space roles of the user
+func (c *Client) ListUserRoles(userGuid string) ([]UserRole, error) {
+	if c.ApiVersion == "v3" {
+		return c.listUserRolesV3(userGuid)
+	}
+	return c.listUserRolesV2(userGuid)
+}
+
+// Returns the users of a CF API v2 role list endpoint
+// The CF API v2 doesn't know the origin of users, so that is looked up in UAA
+func (c *Client) listRoleUsersV2(path string) ([]User, error) {
+	items, err := c.listV2(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var resources []v2User
+	if err := unmarshalAll(items, &resources); err != nil {
+		return nil, err
+	}
+	// Look up the origins of all users at once
+	var ids []string
+	for _, r := range resources {
+		ids = append(ids, r.Metadata.GUID)
+	}
+	uaaUsers, err := c.ListUAAUsersByID(ids)
+	if err != nil {
+		return nil, errors.New("Failed to check UAA for the origin of users: " + err.Error())
+	}
+	origins := make(map[string]string)
+	for _, u := range uaaUsers {
+		origins[u.ID] = u.Origin
+	}
+	var users []User
+	for _, r := range resources {
+		origin, ok := origins[r.Metadata.GUID]
+		if !ok {
+			return nil, errors.New("Failed to check UAA for the origin of user '" + r.Entity.Username + "': " + ErrNotFound.Error())
+		}
+		users = append(users, User{GUID: r.Metadata.GUID, Username: r.Entity.Username, Origin: origin})
+	}
+	return users, nil
+}
+
+// Returns all roles of the user from the CF API v2 user summary
+func (c *Client) listUserRolesV2(userGuid string) ([]UserRole, error) {
+	type guidList []struct {
+		Metadata v2Metadata `json:"metadata"`
+	}
+	var summary struct {
+		Entity struct {
+			Organizations []struct {
+				Metadata v2Metadata `json:"metadata"`
+				Entity   struct {
+					Spaces guidList `json:"spaces"`
+				} `json:"entity"`
+			} `json:"organizations"`
+			ManagedOrganizations        guidList `json:"managed_organizations"`
+			BillingManagedOrganizations guidList `json:"billing_managed_organizations"`
+			AuditedOrganizations        guidList `json:"audited_organizations"`
+			Spaces                      guidList `json:"spaces"`
+			ManagedSpaces               guidList `json:"managed_spaces"`
+			AuditedSpaces               guidList `json:"audited_spaces"`
+		} `json:"entity"`
+	}
+	if err := c.do("GET", c.ApiEndpoint+"/v2/users/"+url.PathEscape(userGuid)+"/summary", nil, nil, &summary, 200); err != nil {
+		return nil, err
+	}
+	var roles []UserRole
+	// The space role lists only contain the space GUID, so find out to which org every space belongs
+	spaceOrgs := make(map[string]string)
+	for _, org := range summary.Entity.Organizations {
+		roles = append(roles, UserRole{Type: OrgUser, OrgGUID: org.Metadata.GUID})
+		for _, space := range org.Entity.Spaces {
+			spaceOrgs[space.Metadata.GUID] = org.Metadata.GUID
+		}
+	}
+	orgRoles := map[Role]guidList{
+		OrgManager:        summary.Entity.ManagedOrganizations,
+		OrgBillingManager: summary.Entity.BillingManagedOrganizations,
+		OrgAuditor:        summary.Entity.AuditedOrganizations,
+	}
+	for role, orgs := range orgRoles {
+		for _, org := range orgs {
+			roles = append(roles, UserRole{Type: role, OrgGUID: org.Metadata.GUID})
+		}
+	}
+	spaceRoles := map[Role]guidList{
+		SpaceDeveloper: summary.Entity.Spaces,
+		SpaceManager:   summary.Entity.ManagedSpaces,
+		SpaceAuditor:   summary.Entity.AuditedSpaces,
+	}
+	for role, spaces := range spaceRoles {
+		for _, space := range spaces {
+			roles = append(roles, UserRole{Type: role, OrgGUID: spaceOrgs[space.Metadata.GUID], SpaceGUID: space.Metadata.GUID})
+		}
+	}
+	return roles, nil
+}
+
+// Returns the CF API v3 roles of the type for the user in the org or space
+// relation is either "organization" or "space"
+func (c *Client) findRolesV3(role Role, userGuid string, relation string, targetGuid string) ([]v3Role, error) {
+	q := url.Values{}
+	q.Add("types", string(role))
+	q.Add("user_guids", userGuid)
+	q.Add(relation+"_guids", targetGuid)
+	items, _, err := c.listV3("/v3/roles", q)
+	if err != nil {
+		return nil, err
+	}
+	var roles []v3Role
+	if err := unmarshalAll(items, &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// Creates the CF API v3 role, unless the user already has it
+// Unlike the v2 API, creating a role which already exists fails. So first check if the role is already there
+func (c *Client) assignRoleV3(role Role, userGuid string, relation string, targetGuid string) error {
+	roles, err := c.findRolesV3(role, userGuid, relation, targetGuid)
+	if err != nil {
+		return err
+	}
+	if len(roles) > 0 {
+		// Nothing to do
+		return nil
+	}
+	type relationship struct {
+		Data struct {
+			GUID string `json:"guid"`
+		} `json:"data"`
+	}
+	var user, target relationship
+	user.Data.GUID = userGuid
+	target.Data.GUID = targetGuid
+	payload := map[string]interface{}{
+		"type": role,
+		"relationships": map[string]relationship{
+			"user":   user,
+			relation: target,
+		},
+	}
+	return c.do("POST", c.ApiEndpoint+"/v3/roles", nil, payload, nil, 201)
+}
+
+// Deletes the CF API v3 role, if the user has it
+func (c *Client) removeRoleV3(role Role, userGuid string, relation string, targetGuid string) error {
+	roles, err := c.findRolesV3(role, userGuid, relation, targetGuid)
+	if err != nil {
+		return err
+	}
+	for _, r := range roles {
+		// Deleting a role is done asynchronously by CF (HTTP 202)
+		if err := c.do("DELETE", c.ApiEndpoint+"/v3/roles/"+url.PathEscape(r.GUID), nil, nil, nil, 202, 204); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the users which have the CF API v3 role in the org or space
+func (c *Client) listRoleUsersV3(role Role, relation string, targetGuid string) ([]User, error) {
+	// Include the users, so we get their username and origin in the same call
+	q := url.Values{}
+	q.Add("types", string(role))
+	q.Add(relation+"_guids", targetGuid)
+	q.Add("include", "user")
+	items, included, err := c.listV3("/v3/roles", q)
+	if err != nil {
+		return nil, err
+	}
+	var roles []v3Role
+	if err := unmarshalAll(items, &roles); err != nil {
+		return nil, err
+	}
+	var includedUsers []v3User
+	if err := unmarshalAll(included["users"], &includedUsers); err != nil {
+		return nil, err
+	}
+	// Index the included users by GUID
+	usersByGuid := make(map[string]v3User)
+	for _, u := range includedUsers {
+		usersByGuid[u.GUID] = u
+	}
+	var users []User
+	for _, r := range roles {
+		u, ok := usersByGuid[r.Relationships.User.guid()]
+		if !ok {
+			return nil, errors.New("CF did not include the user of role '" + r.GUID + "'")
+		}
+		users = append(users, User{GUID: u.GUID, Username: u.Username, Origin: u.Origin})
+	}
+	return users, nil
+}
+
+// Returns all CF API v3 roles of the user
+func (c *Client) listUserRolesV3(userGuid string) ([]UserRole, error) {
+	// Include the spaces, so we know to which org a space role belongs
+	q := url.Values{}
+	q.Add("user_guids", userGuid)
+	q.Add("include", "space")
+	items, included, err := c.listV3("/v3/roles", q)
+	if err != nil {
+		return nil, err
+	}
+	var roles []v3Role
+	if err := unmarshalAll(items, &roles); err != nil {
+		return nil, err
+	}
+	var includedSpaces []v3NamedResource
+	if err := unmarshalAll(included["spaces"], &includedSpaces); err != nil {
+		return nil, err
+	}
+	spaceOrgs := make(map[string]string)
+	for _, space := range includedSpaces {
+		spaceOrgs[space.GUID] = space.Relationships.Organization.guid()
+	}
+	var userRoles []UserRole
+	for _, r := range roles {
+		if spaceGuid := r.Relationships.Space.guid(); spaceGuid != "" {
+			userRoles = append(userRoles, UserRole{Type: r.Type, OrgGUID: spaceOrgs[spaceGuid], SpaceGUID: spaceGuid})
+		} else {
+			userRoles = append(userRoles, UserRole{Type: r.Type, OrgGUID: r.Relationships.Organization.guid()})
+		}
+	}
+	return userRoles, nil
+}
@@ -0,0 +1,106 @@
+package cfclient
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRolesV3(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(c *Client) error
+		// Roles which already exist, returned by the search
+		existing string
+		expected []string
+		// The payload of the created role
+		payload string
+	}{
+		{
+			name:     "assign org role",
+			call:     func(c *Client) error { return c.AssignOrgRole("org-1", OrgManager, "guid-alice") },
+			expected: []string{"GET /v3/roles?organization_guids=org-1&per_page=100&types=organization_manager&user_guids=guid-alice", "POST /v3/roles"},
+			payload:  `{"relationships":{"organization":{"data":{"guid":"org-1"}},"user":{"data":{"guid":"guid-alice"}}},"type":"organization_manager"}`,
+		},
+		{
+			name:     "assign space role",
+			call:     func(c *Client) error { return c.AssignSpaceRole("space-1", SpaceDeveloper, "guid-alice") },
+			expected: []string{"GET /v3/roles?per_page=100&space_guids=space-1&types=space_developer&user_guids=guid-alice", "POST /v3/roles"},
+			payload:  `{"relationships":{"space":{"data":{"guid":"space-1"}},"user":{"data":{"guid":"guid-alice"}}},"type":"space_developer"}`,
+		},
+		{
+			// Creating a role which exists fails with the CF API v3
+			name:     "assign existing role",
+			call:     func(c *Client) error { return c.AssignOrgRole("org-1", OrgManager, "guid-alice") },
+			existing: `[{"guid": "role-1", "type": "organization_manager"}]`,
+			expected: []string{"GET /v3/roles?organization_guids=org-1&per_page=100&types=organization_manager&user_guids=guid-alice"},
+		},
+		{
+			name:     "remove space role",
+			call:     func(c *Client) error { return c.RemoveSpaceRole("space-1", SpaceDeveloper, "guid-alice") },
+			existing: `[{"guid": "role-1", "type": "space_developer"}]`,
+			expected: []string{"GET /v3/roles?per_page=100&space_guids=space-1&types=space_developer&user_guids=guid-alice", "DELETE /v3/roles/role-1"},
+		},
+		{
+			name:     "remove missing role",
+			call:     func(c *Client) error { return c.RemoveOrgRole("org-1", OrgAuditor, "guid-alice") },
+			expected: []string{"GET /v3/roles?organization_guids=org-1&per_page=100&types=organization_auditor&user_guids=guid-alice"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests []string
+			var payload string
+			c, stop := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, strings.TrimSuffix(r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery, "?"))
+				switch r.Method {
+				case "GET":
+					existing := test.existing
+					if existing == "" {
+						existing = "[]"
+					}
+					w.Write([]byte(`{"pagination": {"next": null}, "resources": ` + existing + `}`))
+				case "POST":
+					body, _ := ioutil.ReadAll(r.Body)
+					payload = string(body)
+					w.WriteHeader(201)
+				case "DELETE":
+					w.WriteHeader(202)
+				}
+			})
+			defer stop()
+			c.ApiVersion = "v3"
+			if err := test.call(c); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(requests, "\n") != strings.Join(test.expected, "\n") {
+				t.Errorf("expected requests:\n%v\ngot:\n%v", strings.Join(test.expected, "\n"), strings.Join(requests, "\n"))
+			}
+			if payload != test.payload {
+				t.Errorf("expected payload %v, got %v", test.payload, payload)
+			}
+		})
+	}
+}
+
+func TestListRoleUsersV3(t *testing.T) {
+	c, stop := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"pagination": map[string]interface{}{"next": nil},
+			"resources": []map[string]interface{}{{"guid": "role-1", "type": "space_developer",
+				"relationships": map[string]interface{}{"user": map[string]interface{}{"data": map[string]string{"guid": "guid-alice"}}}}},
+			"included": map[string]interface{}{"users": []map[string]string{{"guid": "guid-alice", "username": "alice@example.com", "origin": "sso"}}},
+		})
+	})
+	defer stop()
+	c.ApiVersion = "v3"
+	users, err := c.ListSpaceRoleUsers("space-1", SpaceDeveloper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0] != (User{GUID: "guid-alice", Username: "alice@example.com", Origin: "sso"}) {
+		t.Errorf("unexpected users %+v", users)
+	}
+}
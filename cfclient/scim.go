@@ -0,0 +1,13 @@
+package cfclient
+
+import (
+	"strings"
+)
+
+// Returns the value as quoted SCIM filter string, e.g. for a filter like 'userName eq "..."'
+// Backslashes and double quotes in the value are escaped, so the value can't break out of the filter
+func ScimQuote(value string) string {
+	escaped := strings.Replace(value, `\`, `\\`, -1)
+	escaped = strings.Replace(escaped, `"`, `\"`, -1)
+	return `"` + escaped + `"`
+}
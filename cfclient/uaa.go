@@ -0,0 +1,112 @@
+package cfclient
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Number of users which are searched in UAA with a single request by ListUAAUsersByID
+const uaaUserBatchSize = 50
+
+// A user in UAA
+// UAA users follow the SCIM 2.0 user schema, so the type is also used for users of other SCIM servers
+type UAAUser struct {
+	ID            string     `json:"id"`
+	UserName      string     `json:"userName"`
+	Origin        string     `json:"origin"`
+	ExternalID    string     `json:"externalId"`
+	Active        bool       `json:"active"`
+	LastLogonTime int64      `json:"lastLogonTime"`
+	Emails        []UAAEmail `json:"emails,omitempty"`
+}
+
+// An email address of a UAA user
+type UAAEmail struct {
+	Value   string `json:"value"`
+	Primary bool   `json:"primary"`
+}
+
+// Structure for a UAA user search result
+type uaaUserList struct {
+	Resources    []UAAUser `json:"resources"`
+	TotalResults int       `json:"totalResults"`
+}
+
+// Searches the user with the username in UAA
+// Returns ErrNotFound when there is no such user, and an error when there is more than 1 user found
+func (c *Client) FindUAAUser(username string) (*UAAUser, error) {
+	q := url.Values{}
+	q.Add("attributes", "id,externalId,userName,active,origin,lastLogonTime")
+	q.Add("filter", "userName eq "+ScimQuote(username))
+	var users uaaUserList
+	if err := c.do("GET", c.UaaEndpoint+"/Users", q, nil, &users, 200); err != nil {
+		return nil, err
+	}
+	if len(users.Resources) == 0 {
+		return nil, ErrNotFound
+	}
+	if len(users.Resources) > 1 {
+		return nil, errors.New("Search for user '" + username + "' resulted in more than 1 results!")
+	}
+	return &users.Resources[0], nil
+}
+
+// Returns the UAA user with the ID (which is the same as the CF user GUID)
+func (c *Client) GetUAAUser(id string) (*UAAUser, error) {
+	var user UAAUser
+	if err := c.do("GET", c.UaaEndpoint+"/Users/"+url.PathEscape(id), nil, nil, &user, 200); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Returns the UAA users with the IDs. IDs which don't exist in UAA are left out
+// The users are searched in batches, which needs far fewer requests than calling GetUAAUser for every ID
+func (c *Client) ListUAAUsersByID(ids []string) ([]UAAUser, error) {
+	var result []UAAUser
+	for start := 0; start < len(ids); start += uaaUserBatchSize {
+		end := start + uaaUserBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var filters []string
+		for _, id := range ids[start:end] {
+			filters = append(filters, "id eq "+ScimQuote(id))
+		}
+		q := url.Values{}
+		q.Add("attributes", "id,externalId,userName,active,origin,lastLogonTime")
+		q.Add("filter", strings.Join(filters, " or "))
+		q.Add("count", strconv.Itoa(uaaUserBatchSize))
+		var users uaaUserList
+		if err := c.do("GET", c.UaaEndpoint+"/Users", q, nil, &users, 200); err != nil {
+			return nil, err
+		}
+		result = append(result, users.Resources...)
+	}
+	return result, nil
+}
+
+// Creates a new user in UAA for the origin (e.g. the SSO provider name)
+// The username is also used as email address and name of the user
+func (c *Client) CreateUAAUser(username string, origin string) (*UAAUser, error) {
+	payload := map[string]interface{}{
+		"emails": []UAAEmail{{Primary: true, Value: username}},
+		"name": map[string]string{
+			"familyName": username,
+			"givenName":  username,
+		},
+		"origin":   origin,
+		"userName": username,
+	}
+	var user UAAUser
+	if err := c.do("POST", c.UaaEndpoint+"/Users", nil, payload, &user, 201); err != nil {
+		return nil, err
+	}
+	// The response body should contain the GUID of the new user
+	if user.ID == "" {
+		return nil, errors.New("GUID was empty in UAA Api call for user " + username)
+	}
+	return &user, nil
+}
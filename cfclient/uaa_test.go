@@ -0,0 +1,73 @@
+package cfclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestScimQuote(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{value: "alice@example.com", expected: `"alice@example.com"`},
+		{value: `alice" or userName pr or "`, expected: `"alice\" or userName pr or \""`},
+		{value: `alice\`, expected: `"alice\\"`},
+	}
+	for _, test := range tests {
+		if actual := ScimQuote(test.value); actual != test.expected {
+			t.Errorf("expected %v, got %v", test.expected, actual)
+		}
+	}
+}
+
+func TestFindUAAUser(t *testing.T) {
+	var filter string
+	c, stop := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		filter = r.URL.Query().Get("filter")
+		var users []UAAUser
+		if r.URL.Query().Get("filter") == `userName eq "alice@example.com"` {
+			users = append(users, UAAUser{ID: "guid-alice", UserName: "alice@example.com", Origin: "sso"})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"resources": users, "totalResults": len(users)})
+	})
+	defer stop()
+	user, err := c.FindUAAUser("alice@example.com")
+	if err != nil || user.ID != "guid-alice" {
+		t.Errorf("expected alice, got %+v (%v)", user, err)
+	}
+	// The username can't change the filter
+	if _, err := c.FindUAAUser(`bob" or userName pr or "`); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if filter != `userName eq "bob\" or userName pr or \""` {
+		t.Errorf("expected the username to be quoted, got %v", filter)
+	}
+}
+
+func TestCreateUAAUser(t *testing.T) {
+	var payload struct {
+		UserName string     `json:"userName"`
+		Origin   string     `json:"origin"`
+		Emails   []UAAEmail `json:"emails"`
+	}
+	c, stop := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		w.WriteHeader(201)
+		json.NewEncoder(w).Encode(UAAUser{ID: "guid-new", UserName: payload.UserName, Origin: payload.Origin})
+	})
+	defer stop()
+	// Quotes in the username are escaped in the json payload
+	username := `"alice"@example.com`
+	user, err := c.CreateUAAUser(username, "sso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != "guid-new" || payload.UserName != username || payload.Origin != "sso" || len(payload.Emails) != 1 || payload.Emails[0].Value != username {
+		t.Errorf("unexpected payload %+v or user %+v", payload, user)
+	}
+}
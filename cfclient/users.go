@@ -0,0 +1,8 @@
+package cfclient
+
+// Creates the CF user for an existing UAA user
+// The GUID of the CF user is the ID of the UAA user
+func (c *Client) CreateUser(guid string) error {
+	payload := map[string]string{"guid": guid}
+	return c.do("POST", c.ApiEndpoint+"/"+c.ApiVersion+"/users", nil, payload, nil, 201)
+}
@@ -0,0 +1,25 @@
+package cfclient
+
+import (
+	"errors"
+)
+
+// Detects which CF API version should be used, based on the links in the API root
+// v2 is returned as long as the foundation offers it, otherwise v3
+func (c *Client) DetectApiVersion() (string, error) {
+	var root struct {
+		Links map[string]*struct {
+			Href string `json:"href"`
+		} `json:"links"`
+	}
+	if err := c.do("GET", c.ApiEndpoint+"/", nil, nil, &root, 200); err != nil {
+		return "", err
+	}
+	if link := root.Links["cloud_controller_v2"]; link != nil && link.Href != "" {
+		return "v2", nil
+	}
+	if link := root.Links["cloud_controller_v3"]; link != nil && link.Href != "" {
+		return "v3", nil
+	}
+	return "", errors.New("CF API root does not offer API v2 or v3")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+)
+
+// Names of the sources in order of precedence. Set by newCompositeSource
+var sourcePrecedence []string
+
+// A MembershipSource together with the name it was configured with (e.g. "google")
+type namedSource struct {
+	name   string
+	source MembershipSource
+}
+
+// A group of one of the underlying sources of a compositeSource
+type compositeEntry struct {
+	source namedSource
+	group  SourceGroup
+}
+
+// MembershipSource which merges the memberships of several sources
+// Groups of all sources which map to the same Org, Space and Role are merged into one group,
+// holding the union of their members. This makes sure a role is only unset when no source grants it anymore.
+// The order of the sources is their precedence: when several sources have a group for the same Org, Space and Role
+// (compared case-insensitively), the binding and description of the first source are used, and so is its spelling
+// of the email address of a member. The sources of every membership are recorded in order of precedence
+type compositeSource struct {
+	// The sources in order of precedence
+	sources []namedSource
+	// The underlying groups by lowercase group key (see getGroupKey), in order of precedence. Filled by ListGroups
+	entries map[string][]compositeEntry
+}
+
+// Creates a new compositeSource. The sources must be passed in order of precedence
+func newCompositeSource(sources []namedSource) *compositeSource {
+	sourcePrecedence = nil
+	for _, source := range sources {
+		sourcePrecedence = append(sourcePrecedence, source.name)
+	}
+	return &compositeSource{
+		sources: sources,
+		entries: make(map[string][]compositeEntry),
+	}
+}
+
+func (s *compositeSource) ListGroups() ([]SourceGroup, error) {
+	var groups []SourceGroup
+	entries := make(map[string][]compositeEntry)
+	// The sources are listed in order of precedence, so the first source with a group key wins
+	for _, source := range s.sources {
+		// When one source fails, we don't have the full picture. So don't return anything
+		sourceGroups, err := source.source.ListGroups()
+		if err != nil {
+			return groups, err
+		}
+		for _, gr := range sourceGroups {
+			// Get group attributes. A single group can have several bindings
+			bindings, err := scrapeGroupAttributes(gr)
+			if err != nil {
+				// The group is returned as it is. Syncing it fails on the same error, so it is counted as failed group
+				log.Printf("Could not scrape group attributes of group '%v' from source '%v': %v\n", gr.Name, source.name, err)
+				groups = append(groups, gr)
+				continue // Try next group
+			}
+			for _, group := range bindings {
+				// Org and space names can be aliases for the real CF names
+				aliases.resolve(group)
+				key := strings.ToLower(getGroupKey(group))
+				if _, ok := entries[key]; !ok {
+					groups = append(groups, SourceGroup{Name: getGroupKey(group), Description: gr.Description, Groups: []*Group{group}})
+				}
+				entries[key] = append(entries[key], compositeEntry{source: source, group: gr})
+			}
+		}
+	}
+	s.entries = entries
+	return groups, nil
+}
+
+func (s *compositeSource) ListMembers(group SourceGroup) ([]Member, error) {
+	var members []Member
+	// Index of every member in members, by lowercase email address
+	index := make(map[string]int)
+	// Set when one of the sources could only list part of the members
+	var incomplete error
+	// The entries are in order of precedence, so the first source of a member determines its email address
+	for _, entry := range s.entries[strings.ToLower(group.Name)] {
+		sourceMembers, err := entry.source.source.ListMembers(entry.group)
+		if err != nil {
+			if !isIncompleteListing(err) {
+				// When one source fails, the union would be incomplete. So don't return anything
+				return nil, err
+			}
+			incomplete = err
+		}
+		for _, m := range sourceMembers {
+			email := strings.ToLower(m.Email)
+			i, ok := index[email]
+			if !ok {
+				index[email] = len(members)
+				members = append(members, Member{Email: m.Email, Sources: []string{entry.source.name}})
+				continue
+			}
+			// Record every source only once, even when several of its groups grant the membership
+			if !containsString(members[i].Sources, entry.source.name) {
+				members[i].Sources = append(members[i].Sources, entry.source.name)
+			}
+		}
+	}
+	for _, m := range members {
+		sortSources(m.Sources)
+	}
+	return members, incomplete
+}
+
+// Sorts the names of sources in order of precedence. Unknown sources are sorted last
+func sortSources(sources []string) {
+	rank := func(name string) int {
+		for i, s := range sourcePrecedence {
+			if s == name {
+				return i
+			}
+		}
+		return len(sourcePrecedence)
+	}
+	sort.SliceStable(sources, func(i, j int) bool {
+		return rank(sources[i]) < rank(sources[j])
+	})
+}
+
+// Checks if the list contains the string
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
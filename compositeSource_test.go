@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/SpringerPE/cf-user-role-syncher/cfclient"
+)
+
+// MembershipSource with fixed groups and members
+type fakeSource struct {
+	groups []SourceGroup
+	// Members by group name
+	members map[string][]Member
+	// Errors of ListMembers by group name
+	errs map[string]error
+}
+
+func (s *fakeSource) ListGroups() ([]SourceGroup, error) {
+	return s.groups, nil
+}
+
+func (s *fakeSource) ListMembers(group SourceGroup) ([]Member, error) {
+	return s.members[group.Name], s.errs[group.Name]
+}
+
+// Uses the default group name grammar with the prefix 'cfroles'
+func useTestGroupNames(t *testing.T) {
+	grammar, err := newGroupNameGrammar("cfroles", "{prefix}__{org}__{space?}__{role}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupNames = grammar
+}
+
+func TestCompositeSourceMergesGroups(t *testing.T) {
+	useTestGroupNames(t)
+	google := &fakeSource{
+		groups:  []SourceGroup{{Name: "cfroles__org__live__spacedeveloper@example.com"}},
+		members: map[string][]Member{"cfroles__org__live__spacedeveloper@example.com": {{Email: "alice@example.com"}, {Email: "bob@example.com"}}},
+	}
+	ldap := &fakeSource{
+		groups:  []SourceGroup{{Name: "cfroles__org__live__spacedeveloper"}},
+		members: map[string][]Member{"cfroles__org__live__spacedeveloper": {{Email: "Bob@example.com"}, {Email: "carol@example.com"}}},
+	}
+	source := newCompositeSource([]namedSource{{name: "google", source: google}, {name: "ldap", source: ldap}})
+	groups, err := source.ListGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Name != "org/live/spacedeveloper" {
+		t.Fatalf("expected one merged group, got %+v", groups)
+	}
+	members, err := source.ListMembers(groups[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, m := range members {
+		actual = append(actual, m.Email+"="+strings.Join(m.Sources, "+"))
+	}
+	expected := "alice@example.com=google,bob@example.com=google+ldap,carol@example.com=ldap"
+	if strings.Join(actual, ",") != expected {
+		t.Errorf("expected %v, got %v", expected, strings.Join(actual, ","))
+	}
+}
+
+func TestCompositeSourceReportsInvalidGroups(t *testing.T) {
+	useTestGroupNames(t)
+	tests := []struct {
+		name  string
+		group SourceGroup
+	}{
+		{name: "invalid name", group: SourceGroup{Name: "cfroles__org@example.com"}},
+		{name: "unknown role", group: SourceGroup{Name: "cfroles__org__live__superuser@example.com"}},
+		{name: "invalid description", group: SourceGroup{Name: "team@example.com", Description: "cfroles: [{space: live, role: spacedeveloper}]"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			valid := SourceGroup{Name: "cfroles__org__orgmanager@example.com"}
+			source := newCompositeSource([]namedSource{{name: "google", source: &fakeSource{groups: []SourceGroup{valid, test.group}}}})
+			groups, err := source.ListGroups()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(groups) != 2 {
+				t.Fatalf("expected the invalid group to be returned, got %+v", groups)
+			}
+			summary := &cycleSummary{}
+			for _, gr := range groups {
+				listGroup(source, gr, summary)
+			}
+			if summary.Groups != 1 || summary.FailedGroups != 1 {
+				t.Errorf("expected 1 synced and 1 failed group, got %d and %d", summary.Groups, summary.FailedGroups)
+			}
+			if code := summary.exitCode(); code != ExitSyncErrors {
+				t.Errorf("expected exit code %d, got %d", ExitSyncErrors, code)
+			}
+		})
+	}
+}
+
+func TestCompositeSourceIncompleteListings(t *testing.T) {
+	useTestGroupNames(t)
+	google := &fakeSource{
+		groups:  []SourceGroup{{Name: "cfroles__org__orgmanager@example.com"}},
+		members: map[string][]Member{"cfroles__org__orgmanager@example.com": {{Email: "alice@example.com"}}},
+	}
+	tests := []struct {
+		name string
+		// Error of the file source
+		err        error
+		expected   []string
+		incomplete bool
+	}{
+		{name: "complete", expected: []string{"alice@example.com", "bob@example.com"}},
+		// The members of the other source still get their roles, but no roles are unset
+		{name: "incomplete", err: &incompleteListingError{group: "cfroles__org__orgmanager", reason: "test"},
+			expected: []string{"alice@example.com", "bob@example.com"}, incomplete: true},
+		// A complete listing of another source doesn't make up for a failed source
+		{name: "failed", err: errors.New("unavailable")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := &fakeSource{
+				groups:  []SourceGroup{{Name: "cfroles__org__orgmanager"}},
+				members: map[string][]Member{"cfroles__org__orgmanager": {{Email: "bob@example.com"}}},
+				errs:    map[string]error{"cfroles__org__orgmanager": test.err},
+			}
+			source := newCompositeSource([]namedSource{{name: "google", source: google}, {name: "file", source: file}})
+			groups, err := source.ListGroups()
+			if err != nil || len(groups) != 1 {
+				t.Fatalf("expected one merged group, got %+v (%v)", groups, err)
+			}
+			members, err := source.ListMembers(groups[0])
+			if test.err != nil && !test.incomplete {
+				if err == nil || isIncompleteListing(err) || members != nil {
+					t.Errorf("expected the error of the failed source, got %v and %v", members, err)
+				}
+				return
+			}
+			if isIncompleteListing(err) != test.incomplete || (err != nil && !isIncompleteListing(err)) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if emails := memberEmails(members); strings.Join(emails, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("expected %v, got %v", test.expected, emails)
+			}
+		})
+	}
+}
+
+func TestCompositeSourcePrecedence(t *testing.T) {
+	useTestGroupNames(t)
+	google := &fakeSource{
+		groups:  []SourceGroup{{Name: "team@example.com", Description: "cfroles:\n- org: Org\n  space: live\n  role: spacedeveloper\n"}},
+		members: map[string][]Member{"team@example.com": {{Email: "Bob@Example.com"}}},
+	}
+	ldap := &fakeSource{
+		groups:  []SourceGroup{{Name: "cfroles__org__live__spacedeveloper"}},
+		members: map[string][]Member{"cfroles__org__live__spacedeveloper": {{Email: "alice@example.com"}, {Email: "bob@example.com"}}},
+	}
+	tests := []struct {
+		name    string
+		sources []namedSource
+		// The merged group
+		group       string
+		description string
+		expected    string
+	}{
+		{
+			name:        "google first",
+			sources:     []namedSource{{name: "google", source: google}, {name: "ldap", source: ldap}},
+			group:       "Org/live/spacedeveloper",
+			description: google.groups[0].Description,
+			expected:    "Bob@Example.com=google+ldap,alice@example.com=ldap",
+		},
+		{
+			name:     "ldap first",
+			sources:  []namedSource{{name: "ldap", source: ldap}, {name: "google", source: google}},
+			group:    "org/live/spacedeveloper",
+			expected: "alice@example.com=ldap,bob@example.com=ldap+google",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := newCompositeSource(test.sources)
+			groups, err := source.ListGroups()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(groups) != 1 || groups[0].Name != test.group || groups[0].Description != test.description {
+				t.Fatalf("expected the merged group %v, got %+v", test.group, groups)
+			}
+			members, err := source.ListMembers(groups[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actual []string
+			for _, m := range members {
+				actual = append(actual, m.Email+"="+strings.Join(m.Sources, "+"))
+			}
+			if strings.Join(actual, ",") != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, strings.Join(actual, ","))
+			}
+		})
+	}
+}
+
+func TestDesiredStateSourcesInOrderOfPrecedence(t *testing.T) {
+	newCompositeSource([]namedSource{{name: "google"}, {name: "ldap"}, {name: "file"}})
+	fake := &fakeCloudController{
+		orgs:   []cfclient.Org{{GUID: "org-1", Name: "org"}},
+		spaces: []cfclient.Space{{GUID: "space-live", Name: "live", OrgGUID: "org-1"}},
+	}
+	defer fake.start()()
+	// The groups are listed concurrently, so the listings can be in any order
+	orgWide := listingFor("org", "", "spacedeveloper")
+	orgWide.members = []Member{{Email: "alice@example.com", Sources: []string{"ldap", "file"}}}
+	live := listingFor("org", "live", "spacedeveloper")
+	live.members = []Member{{Email: "alice@example.com", Sources: []string{"google"}}}
+	desired := buildDesiredState([]groupListing{orgWide, live}, nil, &cycleSummary{})
+	if sources := desired[liveDeveloper.key()].members["alice@example.com"].Sources; strings.Join(sources, ",") != "google,ldap,file" {
+		t.Errorf("expected the sources in order of precedence, got %v", sources)
+	}
+}
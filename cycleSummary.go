@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Exit codes of 'gmapper sync --once'
+const (
+	ExitOk = 0
+	// Used by log.Fatal, e.g. for configuration errors
+	ExitFatal = 1
+	// The sync cycle completed, but not everything could be synced, or the leadership could not be determined
+	ExitSyncErrors = 2
+	// The sync cycle was interrupted by SIGTERM or SIGINT, or the leadership was lost or could not be renewed
+	ExitInterrupted = 3
+	// Revocations were blocked because they exceeded the revocation limits
+	ExitRevocationsBlocked = 4
+)
+
+// Will hold the outcome of a single sync cycle
+// The counters are updated by several goroutines, so always use count()
+type cycleSummary struct {
+	mu      sync.Mutex
+	Started time.Time
+	// Number of groups which were synced
+	Groups int
+	// Number of groups which could not be synced (e.g. the members could not be listed)
+	FailedGroups int
+	// Number of users created in CF/UAA
+	Created int
+	// Number of users associated with an org
+	Associated int
+	// Number of roles assigned
+	Assigned int
+	// Number of roles unset
+	Unset int
+	// Number of users removed from an org
+	RemovedFromOrg int
+	// Number of roles which were not unset because of the revocation limits
+	BlockedRevocations int
+	// Number of roles which were not unset because the user or role is protected
+	ProtectedRevocations int
+	// Number of roles which are unset when the user is still missing after the grace period
+	PendingRevocations int
+	// Number of errors, including the errors for single members
+	Errors int
+	// Set when the cycle was stopped before all changes were applied (e.g. on SIGTERM)
+	Interrupted bool
+}
+
+// Increases the counter (a field of the summary) by one
+func (s *cycleSummary) count(counter *int) {
+	s.mu.Lock()
+	*counter++
+	s.mu.Unlock()
+}
+
+// Logs the summary of the sync cycle
+func (s *cycleSummary) log() {
+	log.Printf("Sync cycle finished in %v: %d groups synced, %d groups failed, %d users created, %d users associated, %d roles assigned, %d roles unset, %d users removed from org, %d errors\n",
+		time.Since(s.Started).Round(time.Millisecond), s.Groups, s.FailedGroups, s.Created, s.Associated, s.Assigned, s.Unset, s.RemovedFromOrg, s.Errors)
+	if s.Interrupted {
+		log.Println("Sync cycle was interrupted. Not all changes were applied.")
+	}
+	if s.ProtectedRevocations > 0 {
+		log.Printf("%d roles were not unset because the user or role is protected.\n", s.ProtectedRevocations)
+	}
+	if s.PendingRevocations > 0 {
+		log.Printf("%d roles are pending removal, and are unset when the users are still missing after the grace period.\n", s.PendingRevocations)
+	}
+	if s.BlockedRevocations > 0 {
+		log.Printf("ALERT: %d roles were not unset because of the revocation limits.\n", s.BlockedRevocations)
+	}
+}
+
+// Returns the exit code for the outcome of the sync cycle
+func (s *cycleSummary) exitCode() int {
+	if s.Interrupted {
+		return ExitInterrupted
+	}
+	if s.BlockedRevocations > 0 {
+		return ExitRevocationsBlocked
+	}
+	if s.Errors > 0 || s.FailedGroups > 0 {
+		return ExitSyncErrors
+	}
+	return ExitOk
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/SpringerPE/cf-user-role-syncher/cfclient"
+)
+
+// Will hold the members of a group for a single Org/Space role the group is bound to
+type groupListing struct {
+	group   *Group
+	members []Member
+	// False when the member listing of the group is incomplete
+	complete bool
+}
+
+// A single CF org or space role
+// Stored in the syncState as json, see managedTargets
+type roleTarget struct {
+	OrgGuid   string `json:"orgGuid"`
+	OrgName   string `json:"org"`
+	SpaceGuid string `json:"spaceGuid,omitempty"`
+	SpaceName string `json:"space,omitempty"`
+	// The role name as used in group names, e.g. "spacedeveloper"
+	Role   string        `json:"role"`
+	CfRole cfclient.Role `json:"cfRole"`
+}
+
+// Returns a key which uniquely identifies the role target
+func (t roleTarget) key() string {
+	return t.OrgGuid + "/" + t.SpaceGuid + "/" + string(t.CfRole)
+}
+
+// A user who should have a role
+type desiredMember struct {
+	Email string
+	// Names of the sources which grant the role
+	Sources []string
+}
+
+// The users who should have a single role
+type desiredTarget struct {
+	target roleTarget
+	// Members by lowercase email address
+	members map[string]*desiredMember
+	// Set when a group is bound to exactly this role. The role is then unset for users who are not a member anymore
+	// Not set when the role is only granted by an org wide spacedeveloper group, which only assigns roles
+	revoke bool
+	// False when the member listing of any group granting this role is incomplete. The role is then only assigned
+	complete bool
+	// Set when no group is bound to exactly this role anymore, but one was in an earlier cycle
+	// The role is then unset for all SSO users who don't get it from another group
+	unbound bool
+}
+
+// Lists the members of all groups of the source, with a pool of workers
+// Returns false when the listing was stopped (stop closed or leadership lost) or the groups could not be listed
+func listAllGroups(source MembershipSource, stop <-chan struct{}, elector leaderElector, summary *cycleSummary) ([]groupListing, bool) {
+	// Search for all groups used for managing CF roles
+	groups, err := source.ListGroups()
+	if err != nil {
+		// Don't exit, the source could be temporarily unavailable. Try again in the next cycle
+		log.Printf("Unable to retrieve groups: %v\n", err)
+		summary.count(&summary.Errors)
+		return nil, false
+	}
+	if len(groups) == 0 {
+		log.Println("No groups found.")
+		return nil, true
+	}
+	var listings []groupListing
+	var mu sync.Mutex
+	jobs := make(chan SourceGroup)
+	var wg sync.WaitGroup
+	for w := 0; w < groupConcurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for gr := range jobs {
+				groupListings := listGroup(source, gr, summary)
+				mu.Lock()
+				listings = append(listings, groupListings...)
+				mu.Unlock()
+			}
+		}()
+	}
+	// Hand out the groups to the workers
+	for _, gr := range groups {
+		if !shouldContinue(stop, elector) {
+			summary.Interrupted = true
+			break
+		}
+		jobs <- gr
+	} // End for (groups)
+	close(jobs)
+	wg.Wait()
+	return listings, !summary.Interrupted
+}
+
+// Lists the members of a single group of the source, for every Org/Space role the group is bound to
+func listGroup(source MembershipSource, gr SourceGroup, summary *cycleSummary) []groupListing {
+	log.Printf("GROUP: %v\n", gr.Name)
+	// Get group attributes. A single group can have several bindings
+	bindings, err := scrapeGroupAttributes(gr)
+	if err != nil {
+		log.Printf("Could not scrape group attributes: %v\n", err)
+		summary.count(&summary.FailedGroups)
+		return nil
+	}
+	// Search members within this group
+	groupMembers, err := source.ListMembers(gr)
+	// Roles can only be unset when we know all members of the group
+	complete := true
+	if err != nil {
+		if isIncompleteListing(err) {
+			log.Printf("%v. Will not unset any roles for this group.\n", err)
+			summary.count(&summary.Errors)
+		} else {
+			// The bindings are still added, so the roles of the group are not unset for its members
+			log.Printf("Unable to retrieve members in group: %v\n", err)
+			summary.count(&summary.FailedGroups)
+			groupMembers = nil
+		}
+		complete = false
+	}
+	var listings []groupListing
+	for _, group := range bindings {
+		listings = append(listings, groupListing{group: group, members: groupMembers, complete: complete})
+	}
+	if err == nil || isIncompleteListing(err) {
+		summary.count(&summary.Groups)
+	}
+	return listings
+}
+
+// Builds the desired state: the users who should have every role, by role target key
+// Org and space names are resolved to their GUIDs. The org wide spacedeveloper role is expanded to every space in the org
+// When a binding can't be resolved, its members are unknown for every role in the org, so no roles are unset in that org
+// The managed targets are the roles which were bound to a group in earlier cycles (see managedTargets). They are
+// added without members when their group is gone, so the role is unset for its SSO users
+func buildDesiredState(listings []groupListing, managed map[string]roleTarget, summary *cycleSummary) map[string]*desiredTarget {
+	desired := make(map[string]*desiredTarget)
+	// Caches, as many groups are bound to the same org
+	orgGuids := make(map[string]string)
+	orgSpaces := make(map[string][]cfclient.Space)
+	// Orgs with a binding which could not be resolved, by lowercase org name
+	unresolved := make(map[string]bool)
+	for _, listing := range listings {
+		group := listing.group
+		// Get Org GUID from CF
+		orgGuid, ok := orgGuids[strings.ToLower(group.Org)]
+		if !ok {
+			var err error
+			orgGuid, err = getOrgGuid(group.Org)
+			if err != nil {
+				log.Printf("Could not get Org GUID: %v\n", err)
+				summary.count(&summary.Errors)
+				unresolved[strings.ToLower(group.Org)] = true
+				continue // Try next group
+			}
+			orgGuids[strings.ToLower(group.Org)] = orgGuid
+		}
+		group.CfOrgGuid = orgGuid
+		var targets []roleTarget
+		revoke := true
+		if group.Space != "" {
+			// Get the Space GUID
+			spaceGuid, err := getSpaceGuid(group)
+			if err != nil {
+				log.Printf("Could not get Space GUID: %v\n", err)
+				summary.count(&summary.Errors)
+				unresolved[strings.ToLower(group.Org)] = true
+				continue // Try next group
+			}
+			targets = append(targets, roleTarget{OrgGuid: orgGuid, OrgName: group.Org, SpaceGuid: spaceGuid, SpaceName: group.Space, Role: group.Role, CfRole: spaceRoleMap[group.Role]})
+		} else if _, ok := orgRoleMap[group.Role]; ok {
+			targets = append(targets, roleTarget{OrgGuid: orgGuid, OrgName: group.Org, Role: group.Role, CfRole: orgRoleMap[group.Role]})
+		} else {
+			// For members of groups named prefix_org_spacedeveloper, the SpaceDeveloper role is assigned for every space in the org
+			// These roles are only assigned, never unset
+			spaces, ok := orgSpaces[orgGuid]
+			if !ok {
+				var err error
+				spaces, err = cf.ListSpaces(orgGuid)
+				if err != nil {
+					log.Printf("Could not list the spaces of org '%v': %v\n", group.Org, err)
+					summary.count(&summary.Errors)
+					unresolved[strings.ToLower(group.Org)] = true
+					continue // Try next group
+				}
+				orgSpaces[orgGuid] = spaces
+			}
+			for _, space := range spaces {
+				targets = append(targets, roleTarget{OrgGuid: orgGuid, OrgName: group.Org, SpaceGuid: space.GUID, SpaceName: space.Name, Role: group.Role, CfRole: cfclient.SpaceDeveloper})
+			}
+			revoke = false
+		}
+		for _, target := range targets {
+			d, ok := desired[target.key()]
+			if !ok {
+				d = &desiredTarget{target: target, members: make(map[string]*desiredMember), complete: true}
+				desired[target.key()] = d
+			}
+			d.revoke = d.revoke || revoke
+			d.complete = d.complete && listing.complete
+			for _, m := range listing.members {
+				email := strings.ToLower(m.Email)
+				dm, ok := d.members[email]
+				if !ok {
+					dm = &desiredMember{Email: email}
+					d.members[email] = dm
+				}
+				for _, s := range m.Sources {
+					if !containsString(dm.Sources, s) {
+						dm.Sources = append(dm.Sources, s)
+					}
+				}
+				// The listings are in random order, as the groups are listed concurrently
+				sortSources(dm.Sources)
+			}
+		}
+	}
+	// The bindings of groups which could not be scraped or listed are unknown, so any of them could still be bound to the role
+	summary.mu.Lock()
+	failedGroups := summary.FailedGroups
+	summary.mu.Unlock()
+	for key, target := range managed {
+		d, ok := desired[key]
+		if ok && d.revoke {
+			continue
+		}
+		// With failed groups or unresolved bindings in the org, the group might still be there (see below)
+		if failedGroups == 0 && !unresolved[strings.ToLower(target.OrgName)] {
+			log.Printf("No group is bound to %v anymore. The role is unset for its SSO users.\n", describeTarget(target))
+		}
+		if !ok {
+			d = &desiredTarget{target: target, members: make(map[string]*desiredMember), complete: true}
+			desired[key] = d
+		}
+		// Only the members of an org wide spacedeveloper group keep the role
+		d.revoke = true
+		d.unbound = true
+		d.complete = d.complete && failedGroups == 0
+	}
+	// The members of the unresolved bindings could hold any role in the org (e.g. the org wide spacedeveloper group)
+	for org := range unresolved {
+		log.Printf("Not all groups of org '%v' could be resolved. Will not unset any roles in this org.\n", org)
+	}
+	for _, d := range desired {
+		if unresolved[strings.ToLower(d.target.OrgName)] {
+			d.complete = false
+		}
+	}
+	return desired
+}
+
+// Returns the keys of the desired targets, sorted so the plan is always in the same order
+func sortedTargetKeys(desired map[string]*desiredTarget) []string {
+	var keys []string
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// leaseStore which keeps the lease in a file, e.g. on a volume shared by all instances
+// A lock file (the lease file with '.lock' appended) makes sure only one instance updates the lease at a time
+type fileLeaseStore struct {
+	path string
+}
+
+func (s *fileLeaseStore) tryAcquire(holder string, duration time.Duration) (bool, error) {
+	var acquired bool
+	err := s.withLock(func() error {
+		current, err := s.read()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if current.Holder != holder && current.Holder != "" && now.Before(current.Expires) {
+			return nil
+		}
+		if err := s.write(lease{Holder: holder, Expires: now.Add(duration)}); err != nil {
+			return err
+		}
+		acquired = true
+		return nil
+	})
+	return acquired, err
+}
+
+func (s *fileLeaseStore) release(holder string) error {
+	return s.withLock(func() error {
+		current, err := s.read()
+		if err != nil {
+			return err
+		}
+		if current.Holder != holder {
+			return nil
+		}
+		return s.write(lease{})
+	})
+}
+
+// Runs fn while holding the lock file. Waits up to a second when another instance holds the lock
+// A lock file which is older than a minute was left behind by a crashed instance, and is removed
+func (s *fileLeaseStore) withLock(fn func() error) error {
+	lockPath := s.path + ".lock"
+	var lock *os.File
+	var err error
+	for attempt := 0; attempt < 10; attempt++ {
+		lock, err = os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if !os.IsExist(err) {
+			break
+		}
+		if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > time.Minute {
+			os.Remove(lockPath)
+			continue
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if os.IsExist(err) {
+		return errors.New("Lease file '" + s.path + "' is locked by another instance")
+	}
+	if err != nil {
+		return err
+	}
+	lock.Close()
+	defer os.Remove(lockPath)
+	return fn()
+}
+
+// Reads the lease from the file. A missing file means there is no lease
+func (s *fileLeaseStore) read() (lease, error) {
+	var current lease
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return current, nil
+	}
+	if err != nil {
+		return current, err
+	}
+	if len(data) == 0 {
+		return current, nil
+	}
+	err = json.Unmarshal(data, &current)
+	return current, err
+}
+
+// Writes the lease to a temporary file first, so the lease file is never half written
+func (s *fileLeaseStore) write(current lease) error {
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(s.path, data)
+}
+
+// Writes the data to a temporary file in the same directory, and then renames it to path
+// Readers of path never see a half written file
+func writeFileAtomically(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
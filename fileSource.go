@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Declaration of environment variable key names
+const EnvMappingFile string = "MAPPINGFILE"
+
+// Structure of a mapping file (YAML or JSON)
+type mappingFile struct {
+	Roles []struct {
+		Org   string   `yaml:"org"`
+		Space string   `yaml:"space"`
+		Role  string   `yaml:"role"`
+		Users []string `yaml:"users"`
+	} `yaml:"roles"`
+}
+
+// MembershipSource implementation for mapping files, e.g. maintained in a git repo
+// The path can either point to a single file or to a directory of files
+type fileSource struct {
+	path string
+	// Fingerprint of the files which are currently loaded
+	// Used for detecting when the files have changed
+	fingerprint string
+	groups      []SourceGroup
+	members     map[string][]Member
+}
+
+// Creates a new fileSource using the path from the MAPPINGFILE environment variable
+func newFileSource() (*fileSource, error) {
+	if os.Getenv(EnvMappingFile) == "" {
+		return nil, errors.New("Environment variable " + EnvMappingFile + " must be set")
+	}
+	source := &fileSource{path: os.Getenv(EnvMappingFile)}
+	// Load the files once, so an invalid mapping is detected at startup
+	if _, err := source.ListGroups(); err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+func (s *fileSource) ListGroups() ([]SourceGroup, error) {
+	var groups []SourceGroup
+	files, err := s.listFiles()
+	if err != nil {
+		return groups, err
+	}
+	// Only (re)load the files when they have changed
+	fingerprint, err := getFilesFingerprint(files)
+	if err != nil {
+		return groups, err
+	}
+	if fingerprint != s.fingerprint {
+		if err := s.load(files); err != nil {
+			if s.fingerprint == "" {
+				return groups, err
+			}
+			// Keep using the last valid mapping, so a broken change can't revoke any roles
+			log.Printf("Could not reload mapping file(s), will keep using the previous mapping: %v\n", err)
+		} else {
+			log.Println("Loaded mapping file(s) from '" + s.path + "'")
+			s.fingerprint = fingerprint
+		}
+	}
+	// Return copies, so the loaded groups can't be changed by the caller
+	for _, gr := range s.groups {
+		group := *gr.Groups[0]
+		groups = append(groups, SourceGroup{Name: gr.Name, Groups: []*Group{&group}})
+	}
+	return groups, nil
+}
+
+func (s *fileSource) ListMembers(group SourceGroup) ([]Member, error) {
+	members, ok := s.members[group.Name]
+	if !ok {
+		return nil, errors.New("Group '" + group.Name + "' not found in mapping file(s)")
+	}
+	return members, nil
+}
+
+// Returns the mapping files, sorted by name
+func (s *fileSource) listFiles() ([]string, error) {
+	var files []string
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return files, err
+	}
+	if !info.IsDir() {
+		return append(files, s.path), nil
+	}
+	fileInfos, err := ioutil.ReadDir(s.path)
+	if err != nil {
+		return files, err
+	}
+	for _, fileInfo := range fileInfos {
+		switch strings.ToLower(filepath.Ext(fileInfo.Name())) {
+		case ".yml", ".yaml", ".json":
+			if !fileInfo.IsDir() {
+				files = append(files, filepath.Join(s.path, fileInfo.Name()))
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Parses and validates all files
+// Only when all files are valid, the loaded groups and members are replaced
+func (s *fileSource) load(files []string) error {
+	var groups []SourceGroup
+	members := make(map[string][]Member)
+	// Used for de-duplicating users, as the same role can be listed in several files
+	seen := make(map[string]bool)
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		// YAML is a superset of JSON, so both formats can be parsed the same way
+		var mapping mappingFile
+		if err := yaml.UnmarshalStrict(b, &mapping); err != nil {
+			return errors.New("Could not parse mapping file '" + file + "': " + err.Error())
+		}
+		for i, role := range mapping.Roles {
+			group := &Group{
+				Org:   role.Org,
+				Space: role.Space,
+				Role:  role.Role,
+			}
+			if group.Org == "" {
+				return errors.New("Missing org for role " + strconv.Itoa(i+1) + " in mapping file '" + file + "'")
+			}
+			if err := validateGroupRole(group); err != nil {
+				return errors.New(err.Error() + " in mapping file '" + file + "'")
+			}
+			// Every combination of Org, Space and Role is a single group
+			name := getGroupKey(group)
+			if _, ok := members[name]; !ok {
+				groups = append(groups, SourceGroup{Name: name, Groups: []*Group{group}})
+				members[name] = []Member{}
+			}
+			for _, user := range role.Users {
+				if user == "" {
+					return errors.New("Empty user for '" + name + "' in mapping file '" + file + "'")
+				}
+				if !seen[name+" "+user] {
+					seen[name+" "+user] = true
+					members[name] = append(members[name], Member{Email: user})
+				}
+			}
+		}
+	}
+	s.groups = groups
+	s.members = members
+	return nil
+}
+
+// Returns a string which changes whenever one of the files is changed, added or removed
+func getFilesFingerprint(files []string) (string, error) {
+	var fingerprint []string
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		fingerprint = append(fingerprint, file+":"+strconv.FormatInt(info.Size(), 10)+":"+strconv.FormatInt(info.ModTime().UnixNano(), 10))
+	}
+	return strings.Join(fingerprint, ","), nil
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"os"
+)
+
+// Returns the value of the environment variable
+// When the environment variable is not set (or empty), the default value is returned
+func getEnvOrDefault(key string, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
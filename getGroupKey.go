@@ -0,0 +1,10 @@
+package main
+
+// Returns a key which uniquely identifies the combination of Org, Space and Role of the group
+// e.g. "engineering-enablement/live/spacedeveloper" or "engineering-enablement/orgmanager"
+func getGroupKey(group *Group) string {
+	if group.Space != "" {
+		return group.Org + "/" + group.Space + "/" + group.Role
+	}
+	return group.Org + "/" + group.Role
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/SpringerPE/cf-user-role-syncher/cfclient"
+)
+
+// Returns the email address of a SCIM user
+// The primary email address is preferred. When the user has no email addresses,
+// the userName is used in case it is an email address
+func getScimUserEmail(user *cfclient.UAAUser) string {
+	for _, email := range user.Emails {
+		if email.Primary && email.Value != "" {
+			return email.Value
+		}
+	}
+	for _, email := range user.Emails {
+		if email.Value != "" {
+			return email.Value
+		}
+	}
+	if strings.Contains(user.UserName, "@") {
+		return user.UserName
+	}
+	return ""
+}
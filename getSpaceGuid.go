@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/SpringerPE/cf-user-role-syncher/cfclient"
+)
+
+// Returns the GUID of the space of the group
+// group.CfOrgGuid must already be set
+func getSpaceGuid(group *Group) (string, error) {
+	// Search the space by its exact name
+	space, err := cf.GetSpace(group.CfOrgGuid, group.Space)
+	if err == nil {
+		return space.GUID, nil
+	}
+	if err != cfclient.ErrNotFound {
+		return "", err
+	}
+	// No exact match. Fall back to comparing the names case-insensitively,
+	// as names derived from email addresses are always lowercase
+	allSpaces, err := cf.ListSpaces(group.CfOrgGuid)
+	if err != nil {
+		return "", err
+	}
+	var guids []string
+	for _, r := range allSpaces {
+		if strings.EqualFold(r.Name, group.Space) {
+			guids = append(guids, r.GUID)
+		}
+	}
+	if len(guids) != 1 {
+		return "", errors.New("Search for space '" + group.Space + "' did not result in exactly 1 match!")
+	}
+	return guids[0], nil
+}
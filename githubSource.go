@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Declaration of environment variable key names
+const EnvGithubApiEndPoint string = "GITHUBAPIENDPOINT"
+const EnvGithubGraphqlEndPoint string = "GITHUBGRAPHQLENDPOINT"
+const EnvGithubToken string = "GITHUBTOKEN"
+const EnvGithubOrg string = "GITHUBORG"
+const EnvGithubTeamPrefix string = "GITHUBTEAMPREFIX"
+
+// Used for finding the url of the next page in the Link header of a GitHub API response
+var githubNextLinkRegexp = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// Structure for a GitHub team
+type GithubTeam struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// Structure for a member of a GitHub team
+type GithubTeamMember struct {
+	Login string `json:"login"`
+}
+
+// MembershipSource implementation for GitHub organisation teams
+type githubSource struct {
+	endpoint        string
+	graphqlEndpoint string
+	token           string
+	org             string
+	teamPrefix      string
+	httpClient      *http.Client
+	// Verified email addresses by GitHub login
+	// Reset at the start of every sync cycle (ListGroups)
+	emailCache map[string]string
+	// Guards emailCache, as several groups can be listed at the same time
+	emailCacheMu sync.Mutex
+}
+
+// Creates a new githubSource using the values from the environment variables
+func newGithubSource() (*githubSource, error) {
+	if os.Getenv(EnvGithubOrg) == "" {
+		return nil, errors.New("Environment variable " + EnvGithubOrg + " must be set")
+	}
+	endpoint := strings.TrimSuffix(getEnvOrDefault(EnvGithubApiEndPoint, "https://api.github.com"), "/")
+	return &githubSource{
+		endpoint:        endpoint,
+		graphqlEndpoint: getEnvOrDefault(EnvGithubGraphqlEndPoint, endpoint+"/graphql"),
+		token:           os.Getenv(EnvGithubToken),
+		org:             os.Getenv(EnvGithubOrg),
+		teamPrefix:      getEnvOrDefault(EnvGithubTeamPrefix, groupNames.namePrefix()),
+		httpClient:      &http.Client{},
+		emailCache:      make(map[string]string),
+	}, nil
+}
+
+func (s *githubSource) ListGroups() ([]SourceGroup, error) {
+	var groups []SourceGroup
+	s.emailCacheMu.Lock()
+	s.emailCache = make(map[string]string)
+	s.emailCacheMu.Unlock()
+	nextUrl := s.endpoint + "/orgs/" + url.PathEscape(s.org) + "/teams?per_page=100"
+	for nextUrl != "" {
+		var teams []GithubTeam
+		var err error
+		nextUrl, err = s.getPage(nextUrl, &teams)
+		if err != nil {
+			return groups, err
+		}
+		for _, team := range teams {
+			// Only the teams following the naming convention are used for CF roles
+			if strings.HasPrefix(team.Name, s.teamPrefix) {
+				groups = append(groups, SourceGroup{Name: team.Name, ID: team.Slug})
+			}
+		}
+	}
+	return groups, nil
+}
+
+func (s *githubSource) ListMembers(group SourceGroup) ([]Member, error) {
+	var members []Member
+	var unresolved []string
+	nextUrl := s.endpoint + "/orgs/" + url.PathEscape(s.org) + "/teams/" + url.PathEscape(group.ID) + "/members?per_page=100"
+	for nextUrl != "" {
+		var teamMembers []GithubTeamMember
+		var err error
+		nextUrl, err = s.getPage(nextUrl, &teamMembers)
+		if err != nil {
+			return members, err
+		}
+		for _, m := range teamMembers {
+			email, err := s.getVerifiedEmail(m.Login)
+			if err != nil {
+				return members, err
+			}
+			if email == "" {
+				unresolved = append(unresolved, m.Login)
+				continue // Try next member
+			}
+			members = append(members, Member{Email: email})
+		}
+	}
+	// Report the members who can't get a role, as they can't be mapped to a CF user
+	if len(unresolved) > 0 {
+		log.Println("No verified email address found for GitHub user(s) in team '" + group.Name + "': " + strings.Join(unresolved, ", "))
+	}
+	return members, nil
+}
+
+// Returns the email address of the user which is verified for a domain of the GitHub organisation
+// An empty string is returned when the user has no such email address
+func (s *githubSource) getVerifiedEmail(login string) (string, error) {
+	s.emailCacheMu.Lock()
+	email, ok := s.emailCache[login]
+	s.emailCacheMu.Unlock()
+	if ok {
+		return email, nil
+	}
+	// This information is only available through the GraphQL API
+	payload, err := json.Marshal(map[string]interface{}{
+		"query": `query($login: String!, $org: String!) {
+			user(login: $login) { organizationVerifiedDomainEmails(login: $org) }
+		}`,
+		"variables": map[string]string{"login": login, "org": s.org},
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", s.graphqlEndpoint, bytes.NewBuffer(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "bearer "+s.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", errors.New("GitHub GraphQL request for user '" + login + "' failed with HTTP status code " + strconv.Itoa(resp.StatusCode))
+	}
+	var result struct {
+		Data struct {
+			User struct {
+				OrganizationVerifiedDomainEmails []string `json:"organizationVerifiedDomainEmails"`
+			} `json:"user"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if len(result.Errors) > 0 {
+		return "", errors.New("GitHub GraphQL request for user '" + login + "' failed: " + result.Errors[0].Message)
+	}
+	if len(result.Data.User.OrganizationVerifiedDomainEmails) > 0 {
+		email = strings.ToLower(result.Data.User.OrganizationVerifiedDomainEmails[0])
+	}
+	s.emailCacheMu.Lock()
+	s.emailCache[login] = email
+	s.emailCacheMu.Unlock()
+	return email, nil
+}
+
+// Sends a GET request to the GitHub REST API and parses the json response into v
+// Returns the url of the next page, or an empty string for the last page
+func (s *githubSource) getPage(pageUrl string, v interface{}) (string, error) {
+	req, err := http.NewRequest("GET", pageUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "token "+s.token)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", errors.New("GitHub request for '" + pageUrl + "' failed with HTTP status code " + strconv.Itoa(resp.StatusCode))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", err
+	}
+	if match := githubNextLinkRegexp.FindStringSubmatch(resp.Header.Get("Link")); match != nil {
+		return match[1], nil
+	}
+	return "", nil
+}
@@ -1,98 +1,140 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"math/rand"
 	"os"
+	"time"
 
+	"github.com/SpringerPE/cf-user-role-syncher/cfclient"
 	"github.com/SpringerPE/cf-user-role-syncher/token"
 )
 
 // Declaration of environment variable key names
 const EnvCfApiEndPoint string = "CFAPIENDPOINT"
 
-// ApiResult Structure for getting GUID of Orgs and Spaces in CF
-type ApiResult struct {
-	Resources []struct {
-		Metadata struct {
-			GUID string `json:"guid"`
-		} `json:"metadata"`
-	} `json:"resources"`
+// Will hold info for every individual group
+// as every group represent a single combination of Org, Space and Role.
+type Group struct {
+	Org       string
+	Space     string
+	Role      string
+	CfOrgGuid string
 }
 
-// Stucture for getting members of a CF Role
-type RoleMembers struct {
-	Resources []struct {
-		Metadata struct {
-			GUID string `json:"guid"`
-		} `json:"metadata"`
-		Entity struct {
-			Username string `json:"username"`
-		} `json:"entity"`
-	} `json:"resources"`
+// Will hold info for every group as returned by a MembershipSource
+// The group still has to be scraped to find out which Org, Space and Role it is about
+type SourceGroup struct {
+	// Name of the group, e.g. the email address of a Google Group
+	Name string
+	// Identifier the source needs for looking up the group again, e.g. the DN of an LDAP group
+	// Can be left empty when the Name is sufficient
+	ID string
+	// Free text description of the group
+	// Can hold the Org, Space and Role bindings of the group (see scrapeGroupAttributes)
+	Description string
+	// Sources which already know the Org, Space and Role bindings of the group set this
+	// If set, the group name and description will not be scraped
+	Groups []*Group
 }
 
-// Structure for getting name of spaces
-type Spaces struct {
-	Resources []struct {
-		Metadata struct {
-			GUID string `json:"guid"`
-		} `json:"metadata"`
-		Entity struct {
-			Name string `json:"name"`
-		} `json:"entity"`
-	} `json:"resources"`
+// Will hold info for every member of a SourceGroup
+type Member struct {
+	Email string
+	// Names of the sources which granted the membership, in order of precedence
+	// Filled in by the compositeSource
+	Sources []string
 }
 
-// Structure for user details
-// Used when searching on existence of user in UAA
-type User struct {
-	Resources []struct {
-		LastLogonTime int64  `json:"lastLogonTime"`
-		Origin        string `json:"origin"`
-		ExternalID    string `json:"externalId"`
-		Active        bool   `json:"active"`
-		ID            string `json:"id"`
-		UserName      string `json:"userName"`
-	} `json:"resources"`
-	TotalResults int `json:"totalResults"`
+// Every source of group memberships (e.g. Google Groups) must implement this interface
+// The sync loop only works with the plain SourceGroup and Member values
+type MembershipSource interface {
+	// Returns all groups which are used for managing CF roles
+	ListGroups() ([]SourceGroup, error)
+	// Returns all members of a single group
+	ListMembers(group SourceGroup) ([]Member, error)
 }
 
-// Structure which holds the GUID of a user
-// The GUID should be returned when new user is created in UAA
-type UaaGuid struct {
-	ID string `json:"id"`
+// Map for mapping Org Role name to CF role
+// The keys are the role names which can be used in group names
+var orgRoleMap = map[string]cfclient.Role{
+	"orgmanager":     cfclient.OrgManager,
+	"billingmanager": cfclient.OrgBillingManager,
+	"auditor":        cfclient.OrgAuditor,
 }
 
-// Will hold info for every individual group
-// as every group represent a single combination of Org, Space and Role.
-type Group struct {
-	Org       string
-	Space     string
-	Role      string
-	CfOrgGuid string
+// Map for mapping Space Role name to CF role
+// The keys are the role names which can be used in group names
+var spaceRoleMap = map[string]cfclient.Role{
+	"spacemanager":   cfclient.SpaceManager,
+	"spacedeveloper": cfclient.SpaceDeveloper,
+	"spaceauditor":   cfclient.SpaceAuditor,
 }
 
-// This var holds the Oauth Access Token for CF
-// Initializing this with a value similar to 'bearer something' is important
-// This will make CF recognize the Access Token is invalid with the first request to CF
-var cfAccessToken string = "bearer none"
+// The client for the CF API and UAA. Created by newCfClient
+var cf *cfclient.Client
 
 // This message will show when not providing the right cli options
 var cliOptionsMsg = `Possible options:
 - gmapper token
+- gmapper sync [--once] [--dry-run [--output text|json]] [--force-revocations]
+  Without --once, a sync cycle is run every SYNCINTERVAL until the app is stopped (default when no option is given)
+  With --once, a single sync cycle is run. Exit code 0 means success, 2 means the cycle had errors, 4 means revocations were blocked
+  With --dry-run, a single sync cycle only reads from CF/UAA and prints the planned changes to stdout
+  With --force-revocations (only with --once or --dry-run), roles are unset even when the revocation limits are exceeded
 
 `
 
 func main() {
+	// Seed the random numbers used for jitter, so instances don't retry at the same moment
+	rand.Seed(time.Now().UnixNano())
 	// Check command line arguments
 	if len(os.Args) > 1 {
 		switch os.Args[1] {
 		case "token":
 			token.GenGoogleOauthToken()
+		case "sync":
+			opts, err := parseSyncOptions(os.Args[2:])
+			if err == flag.ErrHelp {
+				os.Exit(ExitOk)
+			}
+			if err != nil {
+				// Exit code 2 of the flag package would be mistaken for ExitSyncErrors
+				os.Exit(ExitFatal)
+			}
+			os.Exit(startMapper(opts))
 		default:
 			fmt.Print(cliOptionsMsg)
 		}
 	} else {
-		startMapper()
+		os.Exit(startMapper(syncOptions{}))
+	}
+}
+
+// Parses the command line arguments of 'gmapper sync'. Errors are printed to stderr
+// Returns flag.ErrHelp for -h and --help, after the usage is printed
+func parseSyncOptions(args []string) (syncOptions, error) {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	var opts syncOptions
+	flags.BoolVar(&opts.Once, "once", false, "Run a single sync cycle and exit")
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "Print the planned changes without applying them, and exit")
+	flags.StringVar(&opts.Output, "output", OutputText, "Format of the planned changes of --dry-run: "+OutputText+" or "+OutputJson)
+	flags.BoolVar(&opts.ForceRevocations, "force-revocations", false, "Unset roles even when the revocation limits are exceeded")
+	// The flag set prints its own errors, together with the usage
+	if err := flags.Parse(args); err != nil {
+		return opts, err
+	}
+	var err error
+	if opts.Output != OutputText && opts.Output != OutputJson {
+		err = errors.New("Unknown output format '" + opts.Output + "'")
+	} else if opts.ForceRevocations && !opts.Once && !opts.DryRun {
+		// The limits may only be overridden for a single cycle, which was checked with --dry-run first
+		err = errors.New("--force-revocations can only be used together with --once or --dry-run")
+	}
+	if err != nil {
+		fmt.Fprintln(flags.Output(), err)
 	}
+	return opts, err
 }
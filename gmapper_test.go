@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"testing"
+)
+
+func TestParseSyncOptions(t *testing.T) {
+	// The flag set prints parse errors and the usage to stderr
+	defer func(stderr *os.File) { os.Stderr = stderr }(os.Stderr)
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	os.Stderr = devNull
+	tests := []struct {
+		name     string
+		args     []string
+		expected syncOptions
+		invalid  bool
+	}{
+		{name: "no flags", expected: syncOptions{Output: OutputText}},
+		{name: "dry run", args: []string{"--dry-run", "--output", "json"}, expected: syncOptions{DryRun: true, Output: OutputJson}},
+		{name: "force revocations once", args: []string{"--once", "--force-revocations"}, expected: syncOptions{Once: true, Output: OutputText, ForceRevocations: true}},
+		{name: "force revocations for every cycle", args: []string{"--force-revocations"}, invalid: true},
+		{name: "unknown output", args: []string{"--dry-run", "--output", "yaml"}, invalid: true},
+		{name: "unknown flag", args: []string{"--twice"}, invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts, err := parseSyncOptions(test.args)
+			if test.invalid {
+				if err == nil || err == flag.ErrHelp {
+					t.Errorf("expected an error, got %v", err)
+				}
+				return
+			}
+			if err != nil || opts != test.expected {
+				t.Errorf("expected %+v, got %+v (%v)", test.expected, opts, err)
+			}
+		})
+	}
+	if _, err := parseSyncOptions([]string{"--help"}); err != flag.ErrHelp {
+		t.Errorf("expected flag.ErrHelp, got %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/SpringerPE/cf-user-role-syncher/ratelimit"
+	"github.com/SpringerPE/cf-user-role-syncher/retry"
+	"github.com/SpringerPE/cf-user-role-syncher/token"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/admin/directory/v1"
+)
+
+// Declaration of environment variable key names
+const EnvGoogleMaxNestingDepth string = "GOOGLEMAXNESTINGDEPTH"
+
+// MembershipSource implementation for Google Groups (Google Directory API)
+type googleSource struct {
+	service *admin.Service
+	// Maximum depth for expanding nested groups
+	maxDepth int
+	// Number of direct members by group email, as reported by ListGroups
+	// Used for checking if the member listing is complete
+	directMembersCount map[string]int64
+}
+
+// Creates a new googleSource using the Google oauth values from the environment variables
+func newGoogleSource() (*googleSource, error) {
+	// Load oauth.Config (e.g. Google oauth endpoint)
+	oauthConf := token.GetOauthConfig()
+	// Load oauth.Token for Google (e.g RefreshToken)
+	oauthTok := token.GetOauthToken()
+	// Retry failed requests to Google, including refreshing the Google Access Token
+	// Every attempt waits for the rate limiter of Google
+	transport, err := newRetryTransport(&ratelimit.Transport{Limiter: googleRateLimiter}, retry.IdempotentMethod)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: transport})
+	// Create 'Service' so Google Directory (Admin) can be requested
+	// The http client takes care of refreshing the Google Access Token when it expires
+	httpClient := oauthConf.Client(ctx, oauthTok)
+	googleService, err := admin.New(httpClient)
+	if err != nil {
+		return nil, err
+	}
+	maxDepth, err := strconv.Atoi(getEnvOrDefault(EnvGoogleMaxNestingDepth, "10"))
+	if err != nil {
+		return nil, errors.New("Not a valid number in " + EnvGoogleMaxNestingDepth + ": " + err.Error())
+	}
+	return &googleSource{service: googleService, maxDepth: maxDepth, directMembersCount: make(map[string]int64)}, nil
+}
+
+func (s *googleSource) ListGroups() ([]SourceGroup, error) {
+	var groups []SourceGroup
+	// Search for all Google Groups matching the search pattern
+	groupsCall := s.service.Groups.List().Customer("my_customer")
+	if prefix := groupNames.namePrefix(); prefix != "" {
+		groupsCall = groupsCall.Query("email:" + prefix + "*")
+	}
+	directMembersCount := make(map[string]int64)
+	// Read all pages of the search result
+	err := groupsCall.MaxResults(200).Pages(context.Background(), func(groupsRes *admin.Groups) error {
+		for _, gr := range groupsRes.Groups {
+			groups = append(groups, SourceGroup{Name: gr.Email, Description: gr.Description})
+			directMembersCount[strings.ToLower(gr.Email)] = gr.DirectMembersCount
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.directMembersCount = directMembersCount
+	return groups, nil
+}
+
+func (s *googleSource) ListMembers(group SourceGroup) ([]Member, error) {
+	var members []Member
+	// Collect the users of this group and of all nested groups
+	seen := make(map[string]bool)
+	visited := make(map[string]bool)
+	if err := s.collectMembers(group.Name, 0, visited, seen, &members); err != nil {
+		if isIncompleteListing(err) {
+			// Return the members we did find, together with the error
+			return members, err
+		}
+		return nil, err
+	}
+	return members, nil
+}
+
+// Adds the users of the group to members. Nested groups are expanded until the configured maximum depth
+// Only members of type USER are added, so a group address is never created as user in UAA
+func (s *googleSource) collectMembers(groupEmail string, depth int, visited map[string]bool, seen map[string]bool, members *[]Member) error {
+	// The members of groups nested too deeply are unknown, so the listing is incomplete
+	// Checked before marking the group as visited, so it is still expanded when it is reached through a shorter path
+	if depth > s.maxDepth {
+		return &incompleteListingError{
+			group:  groupEmail,
+			reason: "nested deeper than the maximum depth of " + strconv.Itoa(s.maxDepth),
+		}
+	}
+	// Cycle detection: every group is only expanded once
+	if visited[strings.ToLower(groupEmail)] {
+		return nil
+	}
+	visited[strings.ToLower(groupEmail)] = true
+	// Search members within this group, reading all pages of the result
+	var groupMembers []*admin.Member
+	err := s.service.Members.List(groupEmail).MaxResults(200).Pages(context.Background(), func(groupMembersRes *admin.Members) error {
+		groupMembers = append(groupMembers, groupMembersRes.Members...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Guard against a listing which silently misses members
+	var incomplete error
+	if count, ok := s.directMembersCount[strings.ToLower(groupEmail)]; ok && int64(len(groupMembers)) < count {
+		incomplete = &incompleteListingError{
+			group:  groupEmail,
+			reason: "found " + strconv.Itoa(len(groupMembers)) + " members, but the group has " + strconv.FormatInt(count, 10) + " members",
+		}
+	}
+	for _, m := range groupMembers {
+		switch m.Type {
+		case "USER":
+			if !seen[strings.ToLower(m.Email)] {
+				seen[strings.ToLower(m.Email)] = true
+				*members = append(*members, Member{Email: m.Email})
+			}
+		case "GROUP":
+			// Nested group: add its members as well
+			if err := s.collectMembers(m.Email, depth+1, visited, seen, members); err != nil {
+				if !isIncompleteListing(err) {
+					return err
+				}
+				incomplete = err
+			}
+		default:
+			log.Println("Member '" + m.Email + "' of group '" + groupEmail + "' has type " + m.Type + ", which is not supported. Skipping.")
+		}
+	}
+	return incomplete
+}
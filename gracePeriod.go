@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+// Declaration of environment variable key names
+const EnvRevocationGracePeriod string = "REVOCATIONGRACEPERIOD"
+
+// Time a user must be missing from the sources before a role is unset. 0 disables the grace period
+// Set by loadGracePeriod
+var gracePeriod time.Duration
+
+// Keeps the pending removals and the managed targets between sync cycles. Set by loadGracePeriod
+var states stateStore
+
+// Loads the grace period and the store for the pending removals from the environment variables
+func loadGracePeriod() error {
+	var err error
+	gracePeriod, err = time.ParseDuration(getEnvOrDefault(EnvRevocationGracePeriod, "0"))
+	if err != nil || gracePeriod < 0 {
+		return errors.New("Not a valid duration in " + EnvRevocationGracePeriod + ": '" + os.Getenv(EnvRevocationGracePeriod) + "'")
+	}
+	// In memory, the grace period would start again whenever the app restarts, which can be more often than it passes
+	if gracePeriod > 0 && os.Getenv(EnvStateFile) == "" {
+		return errors.New(EnvStateFile + " must be set when " + EnvRevocationGracePeriod + " is set")
+	}
+	states = newStateStore()
+	return nil
+}
+
+// Moves the unassign operations of users who are missing for less than the grace period from the plan to the
+// pending operations, together with the remove-from-org operations which depend on them
+// The state is updated: users who are found missing are added with the current time, and users who are not
+// missing anymore (or whose roles can't be checked this cycle) are removed, so their grace period starts again
+// Returns the number of pending revocations
+func (p *syncPlan) applyGracePeriod(state *syncState, grace time.Duration, now time.Time) int {
+	missing := make(map[string]bool)
+	pending := make(map[string]bool)
+	for _, op := range p.opsFor(ActionUnassign) {
+		key := op.targetKey() + "/" + op.userGuid
+		missing[key] = true
+		removal, ok := state.PendingRemovals[key]
+		if !ok {
+			removal = pendingRemoval{User: op.User, Org: op.Org, Space: op.Space, Role: op.Role, Since: now}
+			state.PendingRemovals[key] = removal
+		}
+		if now.Sub(removal.Since) < grace {
+			pending[key] = true
+			log.Printf("PENDING: %v. Missing since %v, the role is unset after %v.\n",
+				op.describe(), removal.Since.Format(time.RFC3339), removal.Since.Add(grace).Format(time.RFC3339))
+		}
+	}
+	for key := range state.PendingRemovals {
+		if !missing[key] {
+			delete(state.PendingRemovals, key)
+		}
+	}
+	withheld := p.withholdUnassigns(func(op planOp) bool {
+		return op.Action == ActionUnassign && pending[op.targetKey()+"/"+op.userGuid]
+	})
+	p.Pending = append(p.Pending, withheld...)
+	return countAction(withheld, ActionUnassign)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestApplyGracePeriod(t *testing.T) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	bobKey := orgManager.key() + "/guid-bob"
+	carolKey := liveDeveloper.key() + "/guid-carol"
+	tests := []struct {
+		name string
+		// Pending removals from the previous cycle, by key
+		since map[string]time.Time
+		// Number of pending revocations
+		count    int
+		expected []string
+		pending  []string
+		// Pending removals after the cycle, by key
+		stored map[string]time.Time
+	}{
+		{
+			name:     "first found missing",
+			count:    2,
+			expected: []string{"assign alice org orgmanager"},
+			pending:  []string{"unassign bob org orgmanager", "unassign carol org/live spacedeveloper", "remove-from-org bob org"},
+			stored:   map[string]time.Time{bobKey: now, carolKey: now},
+		},
+		{
+			name:     "grace period passed",
+			since:    map[string]time.Time{bobKey: now.Add(-2 * time.Hour), carolKey: now.Add(-time.Hour)},
+			expected: []string{"assign alice org orgmanager", "unassign bob org orgmanager", "unassign carol org/live spacedeveloper", "remove-from-org bob org"},
+			stored:   map[string]time.Time{bobKey: now.Add(-2 * time.Hour), carolKey: now.Add(-time.Hour)},
+		},
+		{
+			name:     "grace period passed for one user",
+			since:    map[string]time.Time{bobKey: now.Add(-30 * time.Minute), carolKey: now.Add(-2 * time.Hour)},
+			count:    1,
+			expected: []string{"assign alice org orgmanager", "unassign carol org/live spacedeveloper"},
+			pending:  []string{"unassign bob org orgmanager", "remove-from-org bob org"},
+			stored:   map[string]time.Time{bobKey: now.Add(-30 * time.Minute), carolKey: now.Add(-2 * time.Hour)},
+		},
+		{
+			name: "users who are back are forgotten",
+			since: map[string]time.Time{bobKey: now.Add(-30 * time.Minute), carolKey: now.Add(-30 * time.Minute),
+				orgManager.key() + "/guid-alice": now.Add(-2 * time.Hour)},
+			count:    2,
+			expected: []string{"assign alice org orgmanager"},
+			pending:  []string{"unassign bob org orgmanager", "unassign carol org/live spacedeveloper", "remove-from-org bob org"},
+			stored:   map[string]time.Time{bobKey: now.Add(-30 * time.Minute), carolKey: now.Add(-30 * time.Minute)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state, _ := decodeState(nil)
+			for key, since := range test.since {
+				state.PendingRemovals[key] = pendingRemoval{Since: since}
+			}
+			plan := planWith(planOp{Action: ActionAssign, User: "alice", Org: "org", Role: "orgmanager"},
+				unassignOp(orgManager, "bob"), removeFromOrgOp("bob"), unassignOp(liveDeveloper, "carol"))
+			if count := plan.applyGracePeriod(state, time.Hour, now); count != test.count {
+				t.Errorf("expected %d pending revocations, got %d", test.count, count)
+			}
+			expectOps(t, "planned", plan.Ops, test.expected)
+			expectOps(t, "pending", plan.Pending, test.pending)
+			if len(state.PendingRemovals) != len(test.stored) {
+				t.Errorf("expected %d pending removals, got %+v", len(test.stored), state.PendingRemovals)
+			}
+			for key, since := range test.stored {
+				if !state.PendingRemovals[key].Since.Equal(since) {
+					t.Errorf("expected pending removal '%v' since %v, got %+v", key, since, state.PendingRemovals[key])
+				}
+			}
+		})
+	}
+}
+
+// stateStore which can't be read
+type brokenStateStore struct{}
+
+func (brokenStateStore) load() (*syncState, error)   { return nil, errors.New("unreadable") }
+func (brokenStateStore) save(state *syncState) error { return errors.New("unwritable") }
+
+func TestApplyGracePeriodWithoutState(t *testing.T) {
+	defer func(previous stateStore, grace time.Duration) { states, gracePeriod = previous, grace }(states, gracePeriod)
+	states, gracePeriod = brokenStateStore{}, time.Hour
+	summary := &cycleSummary{}
+	state := loadSyncState(summary)
+	if state != nil || summary.Errors != 1 {
+		t.Errorf("expected no state and 1 error, got %+v and %d errors", state, summary.Errors)
+	}
+	plan := planWith(unassignOp(orgManager, "bob"), removeFromOrgOp("bob"))
+	// Without the state it is unknown since when the users are missing, so nothing is unset
+	if count := applyGracePeriod(plan, state); count != 1 || len(plan.Ops) != 0 {
+		t.Errorf("expected all revocations to be pending, got %d pending and %v", count, describeOps(plan.Ops))
+	}
+}
+
+func TestFileStateStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gmapper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := &fileStateStore{path: filepath.Join(dir, "state")}
+	// A missing file is an empty state
+	state, err := store.load()
+	if err != nil || len(state.PendingRemovals) != 0 {
+		t.Fatalf("expected an empty state, got %+v (%v)", state, err)
+	}
+	since := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	state.PendingRemovals["key"] = pendingRemoval{User: "bob", Org: "org", Role: "orgmanager", Since: since}
+	if err := store.save(state); err != nil {
+		t.Fatal(err)
+	}
+	state, err = store.load()
+	if err != nil || !state.PendingRemovals["key"].Since.Equal(since) || state.PendingRemovals["key"].User != "bob" {
+		t.Errorf("unexpected state %+v (%v)", state, err)
+	}
+	if err := ioutil.WriteFile(store.path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.load(); err == nil {
+		t.Error("expected an error for a corrupt state file")
+	}
+}
+
+func TestLoadGracePeriod(t *testing.T) {
+	defer func(previous stateStore, grace time.Duration) { states, gracePeriod = previous, grace }(states, gracePeriod)
+	tests := []struct {
+		name      string
+		grace     string
+		stateFile string
+		invalid   bool
+	}{
+		{name: "no grace period"},
+		{name: "grace period with state file", grace: "24h", stateFile: "/tmp/state.json"},
+		// The grace period would start again whenever the app restarts
+		{name: "grace period without state file", grace: "24h", invalid: true},
+		{name: "invalid duration", grace: "a day", stateFile: "/tmp/state.json", invalid: true},
+		{name: "negative duration", grace: "-1h", stateFile: "/tmp/state.json", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer setEnv(EnvRevocationGracePeriod, test.grace)()
+			defer setEnv(EnvStateFile, test.stateFile)()
+			err := loadGracePeriod()
+			if (err != nil) != test.invalid {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Declaration of environment variable key names
+const EnvGroupPrefix string = "GROUPPREFIX"
+const EnvGroupNameTemplate string = "GROUPNAMETEMPLATE"
+
+// Used for finding the placeholders in a group name template, e.g. {org} or {space?}
+var groupNamePlaceholderRegexp = regexp.MustCompile(`\{([a-z]+)(\??)\}`)
+
+// A single segment of a group name, e.g. the org
+type groupNameSegment struct {
+	name     string
+	optional bool
+}
+
+// Describes how Org, Space and Role are encoded in a group name
+type groupNameGrammar struct {
+	prefix    string
+	separator string
+	segments  []groupNameSegment
+}
+
+// The grammar which is used by scrapeGroupAttributes
+// Loaded by loadGroupNameGrammar at startup
+var groupNames *groupNameGrammar
+
+// Loads the group name grammar from the environment variables
+func loadGroupNameGrammar() error {
+	grammar, err := newGroupNameGrammar(getEnvOrDefault(EnvGroupPrefix, "snpaas"), getEnvOrDefault(EnvGroupNameTemplate, "{prefix}__{org}__{space?}__{role}"))
+	if err != nil {
+		return err
+	}
+	groupNames = grammar
+	return nil
+}
+
+// Creates a new groupNameGrammar from a template like "{prefix}__{org}__{space?}__{role}"
+// A '?' marks an optional segment. All segments must be separated by the same separator
+func newGroupNameGrammar(prefix string, template string) (*groupNameGrammar, error) {
+	grammar := &groupNameGrammar{prefix: prefix}
+	matches := groupNamePlaceholderRegexp.FindAllStringSubmatchIndex(template, -1)
+	if len(matches) == 0 || matches[0][0] != 0 || matches[len(matches)-1][1] != len(template) {
+		return nil, errors.New("Group name template '" + template + "' must start and end with a placeholder")
+	}
+	seen := make(map[string]bool)
+	for i, match := range matches {
+		name := template[match[2]:match[3]]
+		optional := match[5] > match[4]
+		switch name {
+		case "prefix", "space":
+		case "org", "role":
+			if optional {
+				return nil, errors.New("Placeholder {" + name + "} can't be optional in group name template '" + template + "'")
+			}
+		default:
+			return nil, errors.New("Unknown placeholder {" + name + "} in group name template '" + template + "'")
+		}
+		if seen[name] {
+			return nil, errors.New("Placeholder {" + name + "} is used more than once in group name template '" + template + "'")
+		}
+		seen[name] = true
+		grammar.segments = append(grammar.segments, groupNameSegment{name: name, optional: optional})
+		// The text between this and the next placeholder is the separator
+		if i < len(matches)-1 {
+			separator := template[match[1]:matches[i+1][0]]
+			if separator == "" || (grammar.separator != "" && separator != grammar.separator) {
+				return nil, errors.New("All placeholders must be separated by the same separator in group name template '" + template + "'")
+			}
+			grammar.separator = separator
+		}
+	}
+	if !seen["org"] || !seen["role"] {
+		return nil, errors.New("Group name template '" + template + "' must contain {org} and {role}")
+	}
+	if seen["prefix"] && prefix == "" {
+		return nil, errors.New("Group name template '" + template + "' contains {prefix}, but no prefix is configured")
+	}
+	if grammar.separator == "" {
+		return nil, errors.New("Group name template '" + template + "' must contain at least two placeholders")
+	}
+	return grammar, nil
+}
+
+// Returns the fixed start of every group name, e.g. "snpaas__"
+// Used by the sources for searching the groups. Empty if the names don't have a fixed start
+func (g *groupNameGrammar) namePrefix() string {
+	if g.segments[0].name == "prefix" {
+		return g.prefix + g.separator
+	}
+	return ""
+}
+
+// Parses the Org, Space and Role from a group name
+func (g *groupNameGrammar) parse(name string) (*Group, error) {
+	parts := strings.Split(name, g.separator)
+	// Determine how many of the optional segments are in the name
+	var required, optional int
+	for _, segment := range g.segments {
+		if segment.optional {
+			optional++
+		} else {
+			required++
+		}
+	}
+	extra := len(parts) - required
+	if extra < 0 || extra > optional {
+		return nil, errors.New("Group name '" + name + "' has " + strconv.Itoa(len(parts)) + " segments, which does not match the group name template")
+	}
+	group := &Group{}
+	i := 0
+	for _, segment := range g.segments {
+		// Optional segments are filled from left to right
+		if segment.optional {
+			if extra == 0 {
+				continue
+			}
+			extra--
+		}
+		part := parts[i]
+		i++
+		if part == "" {
+			return nil, errors.New("Group name '" + name + "' has an empty " + segment.name)
+		}
+		switch segment.name {
+		case "prefix":
+			if part != g.prefix {
+				return nil, errors.New("Group name '" + name + "' does not start with prefix '" + g.prefix + "'")
+			}
+		case "org":
+			group.Org = part
+		case "space":
+			group.Space = part
+		case "role":
+			group.Role = part
+		}
+	}
+	if err := validateGroupRole(group); err != nil {
+		return nil, errors.New(err.Error() + " in group name '" + name + "'")
+	}
+	return group, nil
+}
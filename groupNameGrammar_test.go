@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewGroupNameGrammar(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefix   string
+		template string
+		// Part of the error message. Empty when the template is valid
+		errContains string
+	}{
+		{name: "default", prefix: "cfroles", template: "{prefix}__{org}__{space?}__{role}"},
+		{name: "without prefix", template: "{org}.{space?}.{role}"},
+		{name: "text before the first placeholder", prefix: "cfroles", template: "x{prefix}__{org}__{role}", errContains: "must start and end with a placeholder"},
+		{name: "text after the last placeholder", prefix: "cfroles", template: "{prefix}__{org}__{role}@", errContains: "must start and end with a placeholder"},
+		{name: "no placeholders", template: "cfroles", errContains: "must start and end with a placeholder"},
+		{name: "optional org", template: "{org?}__{role}", errContains: "{org} can't be optional"},
+		{name: "optional role", template: "{org}__{role?}", errContains: "{role} can't be optional"},
+		{name: "unknown placeholder", template: "{org}__{team}__{role}", errContains: "Unknown placeholder {team}"},
+		{name: "placeholder used twice", template: "{org}__{space}__{space}__{role}", errContains: "more than once"},
+		{name: "different separators", template: "{org}__{space}.{role}", errContains: "same separator"},
+		{name: "no separator", template: "{org}{role}", errContains: "same separator"},
+		{name: "without role", template: "{org}__{space}", errContains: "must contain {org} and {role}"},
+		{name: "prefix not configured", template: "{prefix}__{org}__{role}", errContains: "no prefix is configured"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newGroupNameGrammar(test.prefix, test.template)
+			if test.errContains == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.errContains) {
+				t.Errorf("expected an error containing '%v', got %v", test.errContains, err)
+			}
+		})
+	}
+}
+
+func TestParseGroupName(t *testing.T) {
+	tests := []struct {
+		name      string
+		template  string
+		groupName string
+		// The parsed group as "org/space/role", or part of the error message when invalid is set
+		expected string
+		invalid  bool
+	}{
+		{name: "space role", groupName: "cfroles__org__live__spacedeveloper", expected: "org/live/spacedeveloper"},
+		{name: "org role without the optional space", groupName: "cfroles__org__orgmanager", expected: "org/orgmanager"},
+		{name: "org wide spacedeveloper", groupName: "cfroles__org__spacedeveloper", expected: "org/spacedeveloper"},
+		{name: "wrong prefix", groupName: "other__org__orgmanager", expected: "does not start with prefix 'cfroles'", invalid: true},
+		{name: "unknown org role", groupName: "cfroles__org__superuser", expected: "superuser", invalid: true},
+		{name: "org role for a space", groupName: "cfroles__org__live__orgmanager", expected: "orgmanager", invalid: true},
+		{name: "empty org", groupName: "cfroles____live__spacedeveloper", expected: "has an empty org", invalid: true},
+		{name: "empty role", groupName: "cfroles__org__", expected: "has an empty role", invalid: true},
+		{name: "too few segments", groupName: "cfroles__org", expected: "has 2 segments", invalid: true},
+		{name: "too many segments", groupName: "cfroles__org__live__dev__spacedeveloper", expected: "has 5 segments", invalid: true},
+		{name: "custom separator", template: "{prefix}.{org}.{space?}.{role}", groupName: "cfroles.org_1.live.spacemanager", expected: "org_1/live/spacemanager"},
+		{name: "custom separator not used", template: "{prefix}.{org}.{space?}.{role}", groupName: "cfroles__org__orgmanager", expected: "has 1 segments", invalid: true},
+		{name: "role first", template: "{role}-{org}-{space?}", groupName: "auditor-org", expected: "org/auditor"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			template := test.template
+			if template == "" {
+				template = "{prefix}__{org}__{space?}__{role}"
+			}
+			grammar, err := newGroupNameGrammar("cfroles", template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			group, err := grammar.parse(test.groupName)
+			if test.invalid {
+				if err == nil || !strings.Contains(err.Error(), test.expected) {
+					t.Errorf("expected an error containing '%v', got %v", test.expected, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := getGroupKey(group); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
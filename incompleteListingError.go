@@ -0,0 +1,18 @@
+package main
+
+// Error returned by a MembershipSource when it could only list part of the members of a group
+// The returned members can still get their role, but no role may be unset based on the incomplete list
+type incompleteListingError struct {
+	group  string
+	reason string
+}
+
+func (e *incompleteListingError) Error() string {
+	return "Member listing of group '" + e.group + "' is incomplete: " + e.reason
+}
+
+// Checks if the error is an incompleteListingError
+func isIncompleteListing(err error) bool {
+	_, ok := err.(*incompleteListingError)
+	return ok
+}
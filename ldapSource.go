@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ldap.v2"
+)
+
+// Declaration of environment variable key names
+const EnvLdapUrl string = "LDAPURL"
+const EnvLdapBindDn string = "LDAPBINDDN"
+const EnvLdapBindPassword string = "LDAPBINDPASSWORD"
+const EnvLdapGroupBaseDn string = "LDAPGROUPBASEDN"
+const EnvLdapGroupFilter string = "LDAPGROUPFILTER"
+const EnvLdapGroupNameAttribute string = "LDAPGROUPNAMEATTRIBUTE"
+const EnvLdapUserBaseDn string = "LDAPUSERBASEDN"
+const EnvLdapMailAttribute string = "LDAPMAILATTRIBUTE"
+const EnvLdapMaxNestingDepth string = "LDAPMAXNESTINGDEPTH"
+
+// The subset of *ldap.Conn used by the ldapSource
+// Makes it possible to run the ldapSource against any (e.g. an in-process) LDAP server
+type ldapConn interface {
+	Bind(username, password string) error
+	Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error)
+	SearchWithPaging(searchRequest *ldap.SearchRequest, pagingSize uint32) (*ldap.SearchResult, error)
+	Close()
+}
+
+// MembershipSource implementation for LDAP servers (e.g. Active Directory)
+type ldapSource struct {
+	// Opens a new connection to the LDAP server
+	dial           func() (ldapConn, error)
+	bindDn         string
+	bindPassword   string
+	groupBaseDn    string
+	groupFilter    string
+	groupNameAttr  string
+	userBaseDn     string
+	mailAttr       string
+	maxDepth       int
+	pagingSize     uint32
+	groupClassList []string
+}
+
+// Creates a new ldapSource using the values from the environment variables
+func newLdapSource() (*ldapSource, error) {
+	ldapUrl, err := url.Parse(os.Getenv(EnvLdapUrl))
+	if err != nil {
+		return nil, err
+	}
+	if os.Getenv(EnvLdapGroupBaseDn) == "" {
+		return nil, errors.New("Environment variable " + EnvLdapGroupBaseDn + " must be set")
+	}
+	maxDepth, err := strconv.Atoi(getEnvOrDefault(EnvLdapMaxNestingDepth, "10"))
+	if err != nil {
+		return nil, errors.New("Not a valid number in " + EnvLdapMaxNestingDepth + ": " + err.Error())
+	}
+	// Determine how to connect to the LDAP server
+	var dial func() (ldapConn, error)
+	switch ldapUrl.Scheme {
+	case "ldap":
+		host := ldapUrl.Host
+		if ldapUrl.Port() == "" {
+			host += ":389"
+		}
+		dial = func() (ldapConn, error) {
+			return ldap.Dial("tcp", host)
+		}
+	case "ldaps":
+		host := ldapUrl.Host
+		if ldapUrl.Port() == "" {
+			host += ":636"
+		}
+		dial = func() (ldapConn, error) {
+			return ldap.DialTLS("tcp", host, &tls.Config{ServerName: ldapUrl.Hostname()})
+		}
+	default:
+		return nil, errors.New("Not a valid LDAP url in " + EnvLdapUrl + ": '" + os.Getenv(EnvLdapUrl) + "'")
+	}
+	return &ldapSource{
+		dial:          dial,
+		bindDn:        os.Getenv(EnvLdapBindDn),
+		bindPassword:  os.Getenv(EnvLdapBindPassword),
+		groupBaseDn:   os.Getenv(EnvLdapGroupBaseDn),
+		groupFilter:   getEnvOrDefault(EnvLdapGroupFilter, "(cn="+ldap.EscapeFilter(groupNames.namePrefix())+"*)"),
+		groupNameAttr: getEnvOrDefault(EnvLdapGroupNameAttribute, "cn"),
+		userBaseDn:    os.Getenv(EnvLdapUserBaseDn),
+		mailAttr:      getEnvOrDefault(EnvLdapMailAttribute, "mail"),
+		maxDepth:      maxDepth,
+		pagingSize:    500,
+		// Object classes which identify an entry as a group
+		groupClassList: []string{"group", "groupOfNames", "groupOfUniqueNames"},
+	}, nil
+}
+
+// Opens a new connection and binds with the configured credentials
+func (s *ldapSource) connect() (ldapConn, error) {
+	conn, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	if s.bindDn != "" {
+		if err := conn.Bind(s.bindDn, s.bindPassword); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (s *ldapSource) ListGroups() ([]SourceGroup, error) {
+	var groups []SourceGroup
+	conn, err := s.connect()
+	if err != nil {
+		return groups, err
+	}
+	defer conn.Close()
+	// Search for all groups matching the configured filter
+	searchRequest := ldap.NewSearchRequest(
+		s.groupBaseDn, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		s.groupFilter, []string{s.groupNameAttr, "description"}, nil,
+	)
+	result, err := conn.SearchWithPaging(searchRequest, s.pagingSize)
+	if err != nil {
+		return groups, err
+	}
+	for _, entry := range result.Entries {
+		name := entry.GetAttributeValue(s.groupNameAttr)
+		if name == "" {
+			log.Println("LDAP group '" + entry.DN + "' has no attribute '" + s.groupNameAttr + "'. Skipping.")
+			continue
+		}
+		groups = append(groups, SourceGroup{Name: name, ID: entry.DN, Description: entry.GetAttributeValue("description")})
+	}
+	return groups, nil
+}
+
+func (s *ldapSource) ListMembers(group SourceGroup) ([]Member, error) {
+	var members []Member
+	conn, err := s.connect()
+	if err != nil {
+		return members, err
+	}
+	defer conn.Close()
+	// Collect the email addresses of all (nested) members of the group
+	emails := make(map[string]bool)
+	visited := make(map[string]bool)
+	err = s.collectMembers(conn, group.ID, 0, visited, emails)
+	if err != nil && !isIncompleteListing(err) {
+		return members, err
+	}
+	for email := range emails {
+		members = append(members, Member{Email: email})
+	}
+	// For an incomplete listing, return the members we did find, together with the error
+	return members, err
+}
+
+// Adds the email addresses of all members of the group with the given DN to emails
+// Nested groups are followed until the configured maximum depth
+// Returns an incompleteListingError when members were left out, e.g. because they could not be read
+func (s *ldapSource) collectMembers(conn ldapConn, groupDn string, depth int, visited map[string]bool, emails map[string]bool) error {
+	// The members of groups nested too deeply are unknown, so the listing is incomplete
+	// Checked before marking the group as visited, so it is still expanded when it is reached through a shorter path
+	if depth > s.maxDepth {
+		return &incompleteListingError{
+			group:  groupDn,
+			reason: "nested deeper than the maximum depth of " + strconv.Itoa(s.maxDepth),
+		}
+	}
+	// Cycle detection: every group is only expanded once
+	if visited[strings.ToLower(groupDn)] {
+		return nil
+	}
+	visited[strings.ToLower(groupDn)] = true
+	// Get the member DNs from the group entry itself ('member' / 'uniqueMember' attribute)
+	memberDns, err := s.getAttributeValues(conn, groupDn, "member")
+	if err != nil {
+		return err
+	}
+	uniqueMemberDns, err := s.getAttributeValues(conn, groupDn, "uniqueMember")
+	if err != nil {
+		return err
+	}
+	var incomplete error
+	var memberEntries []*ldap.Entry
+	for _, memberDn := range append(memberDns, uniqueMemberDns...) {
+		memberEntry, err := s.getEntry(conn, memberDn, []string{"objectClass", s.mailAttr})
+		if err != nil {
+			// The member could be a user who lost the role otherwise
+			incomplete = &incompleteListingError{
+				group:  groupDn,
+				reason: "could not read member '" + memberDn + "': " + err.Error(),
+			}
+			continue // Try next member
+		}
+		memberEntries = append(memberEntries, memberEntry)
+	}
+	// Directories which maintain 'memberOf' on the members are searched as well
+	if s.userBaseDn != "" {
+		searchRequest := ldap.NewSearchRequest(
+			s.userBaseDn, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			"(memberOf="+ldap.EscapeFilter(groupDn)+")", []string{"objectClass", s.mailAttr}, nil,
+		)
+		result, err := conn.SearchWithPaging(searchRequest, s.pagingSize)
+		if err != nil {
+			return err
+		}
+		memberEntries = append(memberEntries, result.Entries...)
+	}
+	for _, memberEntry := range memberEntries {
+		if s.isGroup(memberEntry) {
+			// Nested group: add its members as well
+			if err := s.collectMembers(conn, memberEntry.DN, depth+1, visited, emails); err != nil {
+				if !isIncompleteListing(err) {
+					return err
+				}
+				incomplete = err
+			}
+			continue
+		}
+		email := memberEntry.GetAttributeValue(s.mailAttr)
+		if email == "" {
+			log.Println("LDAP member '" + memberEntry.DN + "' has no attribute '" + s.mailAttr + "'. Skipping.")
+			continue
+		}
+		// Email addresses are used as UAA usernames, which are lowercase
+		emails[strings.ToLower(email)] = true
+	}
+	return incomplete
+}
+
+// Returns all values of the attribute of the entry with the given DN
+// Active Directory returns at most 1500 values at once, e.g. as 'member;range=0-1499'
+// The remaining values are then read range by range, until the range ends with '*'
+func (s *ldapSource) getAttributeValues(conn ldapConn, dn string, attribute string) ([]string, error) {
+	var values []string
+	rangePrefix := strings.ToLower(attribute) + ";range="
+	requested := attribute
+	// The first value of the requested range
+	start := 0
+	for {
+		entry, err := s.getEntry(conn, dn, []string{requested})
+		if err != nil {
+			return nil, err
+		}
+		next := ""
+		for _, attr := range entry.Attributes {
+			name := strings.ToLower(attr.Name)
+			if name == strings.ToLower(attribute) {
+				values = append(values, attr.Values...)
+				continue
+			}
+			if !strings.HasPrefix(name, rangePrefix) {
+				continue
+			}
+			values = append(values, attr.Values...)
+			// The range is '<first>-<last>', with '*' as last for the final range
+			bounds := strings.SplitN(name[len(rangePrefix):], "-", 2)
+			if len(bounds) != 2 {
+				return nil, errors.New("LDAP entry '" + dn + "' has an attribute with an invalid range: '" + attr.Name + "'")
+			}
+			if bounds[1] == "*" {
+				continue
+			}
+			last, err := strconv.Atoi(bounds[1])
+			if err != nil || last < start {
+				return nil, errors.New("LDAP entry '" + dn + "' has an attribute with an invalid range: '" + attr.Name + "'")
+			}
+			start = last + 1
+			next = attribute + ";range=" + strconv.Itoa(start) + "-*"
+		}
+		if next == "" {
+			return values, nil
+		}
+		requested = next
+	}
+}
+
+// Reads a single entry by its DN
+func (s *ldapSource) getEntry(conn ldapConn, dn string, attributes []string) (*ldap.Entry, error) {
+	searchRequest := ldap.NewSearchRequest(
+		dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", attributes, nil,
+	)
+	result, err := conn.Search(searchRequest)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Entries) != 1 {
+		return nil, errors.New("Search for LDAP entry '" + dn + "' did not result in exactly 1 match!")
+	}
+	return result.Entries[0], nil
+}
+
+// Checks if the entry is a group, based on its object classes
+func (s *ldapSource) isGroup(entry *ldap.Entry) bool {
+	for _, objectClass := range entry.GetAttributeValues("objectClass") {
+		for _, groupClass := range s.groupClassList {
+			if strings.EqualFold(objectClass, groupClass) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gopkg.in/ldap.v2"
+)
+
+// In-process LDAP directory for testing the ldapSource through its dial hook
+type fakeDirectory struct {
+	// Attributes by lowercase DN
+	entries map[string]map[string][]string
+	// Original DNs by lowercase DN
+	dns map[string]string
+	// Maximum number of values per attribute in a response, like the MaxValRange of Active Directory
+	// Larger attributes are returned as ranges, e.g. 'member;range=0-1'. 0 for no limit
+	maxValues int
+}
+
+func newFakeDirectory() *fakeDirectory {
+	return &fakeDirectory{entries: make(map[string]map[string][]string), dns: make(map[string]string)}
+}
+
+// Adds an entry. Attribute names are matched case-insensitively
+func (d *fakeDirectory) add(dn string, attributes map[string][]string) {
+	lower := make(map[string][]string)
+	for name, values := range attributes {
+		lower[strings.ToLower(name)] = values
+	}
+	d.entries[strings.ToLower(dn)] = lower
+	d.dns[strings.ToLower(dn)] = dn
+}
+
+func (d *fakeDirectory) dial() (ldapConn, error) {
+	return &fakeLdapConn{dir: d}, nil
+}
+
+type fakeLdapConn struct {
+	dir *fakeDirectory
+}
+
+func (c *fakeLdapConn) Bind(username, password string) error {
+	if password != "secret" {
+		return errors.New("invalid credentials")
+	}
+	return nil
+}
+
+func (c *fakeLdapConn) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	result := &ldap.SearchResult{}
+	if req.Scope == ldap.ScopeBaseObject {
+		attributes, ok := c.dir.entries[strings.ToLower(req.BaseDN)]
+		if !ok {
+			return nil, errors.New("no such object")
+		}
+		result.Entries = append(result.Entries, c.dir.entry(req.BaseDN, attributes, req.Attributes))
+		return result, nil
+	}
+	// Only filters like '(attr=value)' and '(attr=prefix*)' are supported
+	filter := strings.SplitN(strings.Trim(req.Filter, "()"), "=", 2)
+	for lowerDn, attributes := range c.dir.entries {
+		if !strings.HasSuffix(lowerDn, strings.ToLower(req.BaseDN)) {
+			continue
+		}
+		for _, value := range attributes[strings.ToLower(filter[0])] {
+			if strings.EqualFold(value, filter[1]) ||
+				(strings.HasSuffix(filter[1], "*") && strings.HasPrefix(strings.ToLower(value), strings.ToLower(strings.TrimSuffix(filter[1], "*")))) {
+				result.Entries = append(result.Entries, c.dir.entry(c.dir.dns[lowerDn], attributes, req.Attributes))
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+func (c *fakeLdapConn) SearchWithPaging(req *ldap.SearchRequest, pagingSize uint32) (*ldap.SearchResult, error) {
+	return c.Search(req)
+}
+
+func (c *fakeLdapConn) Close() {}
+
+// Returns the entry with the requested attributes. Attributes with more than maxValues values are returned as range
+func (d *fakeDirectory) entry(dn string, attributes map[string][]string, requested []string) *ldap.Entry {
+	entry := &ldap.Entry{DN: dn}
+	for _, name := range requested {
+		parts := strings.SplitN(name, ";range=", 2)
+		values, ok := attributes[strings.ToLower(parts[0])]
+		if !ok {
+			continue
+		}
+		start := 0
+		if len(parts) == 2 {
+			start, _ = strconv.Atoi(strings.SplitN(parts[1], "-", 2)[0])
+		}
+		if len(parts) == 1 && (d.maxValues == 0 || len(values) <= d.maxValues) {
+			entry.Attributes = append(entry.Attributes, ldap.NewEntryAttribute(parts[0], values))
+			continue
+		}
+		end := len(values)
+		last := "*"
+		if d.maxValues > 0 && start+d.maxValues < len(values) {
+			end = start + d.maxValues
+			last = strconv.Itoa(end - 1)
+		}
+		entry.Attributes = append(entry.Attributes,
+			ldap.NewEntryAttribute(parts[0]+";range="+strconv.Itoa(start)+"-"+last, values[start:end]))
+	}
+	return entry
+}
+
+func newTestLdapSource(dir *fakeDirectory) *ldapSource {
+	return &ldapSource{
+		dial:           dir.dial,
+		bindDn:         "cn=gmapper,dc=example,dc=com",
+		bindPassword:   "secret",
+		groupBaseDn:    "ou=groups,dc=example,dc=com",
+		groupFilter:    "(cn=cfroles__*)",
+		groupNameAttr:  "cn",
+		mailAttr:       "mail",
+		maxDepth:       10,
+		pagingSize:     500,
+		groupClassList: []string{"group", "groupOfNames", "groupOfUniqueNames"},
+	}
+}
+
+func addUser(dir *fakeDirectory, name string, memberOf ...string) string {
+	dn := "uid=" + name + ",ou=people,dc=example,dc=com"
+	dir.add(dn, map[string][]string{"objectClass": {"person"}, "mail": {strings.Title(name) + "@Example.com"}, "memberOf": memberOf})
+	return dn
+}
+
+func addGroup(dir *fakeDirectory, name string, members ...string) string {
+	dn := "cn=" + name + ",ou=groups,dc=example,dc=com"
+	dir.add(dn, map[string][]string{"objectClass": {"groupOfNames"}, "cn": {name}, "member": members})
+	return dn
+}
+
+func memberEmails(members []Member) []string {
+	var emails []string
+	for _, m := range members {
+		emails = append(emails, m.Email)
+	}
+	sort.Strings(emails)
+	return emails
+}
+
+func TestLdapListGroups(t *testing.T) {
+	dir := newFakeDirectory()
+	addGroup(dir, "cfroles__org__orgmanager")
+	addGroup(dir, "cfroles__org__live__spacedeveloper")
+	addGroup(dir, "unrelated")
+	dir.add("cn=cfroles__org__auditor,ou=groups,dc=example,dc=com", map[string][]string{
+		"objectClass": {"groupOfNames"}, "cn": {"cfroles__org__auditor"}, "description": {"Auditors"}})
+	groups, err := newTestLdapSource(dir).ListGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]SourceGroup)
+	for _, g := range groups {
+		byName[g.Name] = g
+	}
+	if len(groups) != 3 {
+		t.Fatalf("expected 3 groups, got %v", groups)
+	}
+	if g := byName["cfroles__org__auditor"]; g.ID != "cn=cfroles__org__auditor,ou=groups,dc=example,dc=com" || g.Description != "Auditors" {
+		t.Errorf("unexpected group %+v", g)
+	}
+}
+
+func TestLdapListGroupsBindError(t *testing.T) {
+	source := newTestLdapSource(newFakeDirectory())
+	source.bindPassword = "wrong"
+	if _, err := source.ListGroups(); err == nil {
+		t.Error("expected bind error")
+	}
+}
+
+func TestLdapListMembers(t *testing.T) {
+	tests := []struct {
+		name string
+		// Fills the directory and returns the DN of the group to list
+		setup      func(dir *fakeDirectory, source *ldapSource) string
+		expected   []string
+		incomplete bool
+	}{
+		{
+			name: "member attribute",
+			setup: func(dir *fakeDirectory, source *ldapSource) string {
+				return addGroup(dir, "cfroles__org__orgmanager", addUser(dir, "alice"), addUser(dir, "bob"))
+			},
+			expected: []string{"alice@example.com", "bob@example.com"},
+		},
+		{
+			name: "memberOf attribute",
+			setup: func(dir *fakeDirectory, source *ldapSource) string {
+				source.userBaseDn = "ou=people,dc=example,dc=com"
+				group := addGroup(dir, "cfroles__org__orgmanager", addUser(dir, "alice"))
+				addUser(dir, "bob", group)
+				addUser(dir, "carol", "cn=other,ou=groups,dc=example,dc=com")
+				return group
+			},
+			expected: []string{"alice@example.com", "bob@example.com"},
+		},
+		{
+			name: "nested groups",
+			setup: func(dir *fakeDirectory, source *ldapSource) string {
+				inner := addGroup(dir, "team", addUser(dir, "bob"))
+				return addGroup(dir, "cfroles__org__orgmanager", addUser(dir, "alice"), inner)
+			},
+			expected: []string{"alice@example.com", "bob@example.com"},
+		},
+		{
+			name: "cycle",
+			setup: func(dir *fakeDirectory, source *ldapSource) string {
+				outer := "cn=cfroles__org__orgmanager,ou=groups,dc=example,dc=com"
+				inner := addGroup(dir, "team", addUser(dir, "bob"), outer)
+				return addGroup(dir, "cfroles__org__orgmanager", addUser(dir, "alice"), inner)
+			},
+			expected: []string{"alice@example.com", "bob@example.com"},
+		},
+		{
+			name: "depth limit",
+			setup: func(dir *fakeDirectory, source *ldapSource) string {
+				source.maxDepth = 1
+				deepest := addGroup(dir, "deepest", addUser(dir, "carol"))
+				inner := addGroup(dir, "team", addUser(dir, "bob"), deepest)
+				return addGroup(dir, "cfroles__org__orgmanager", addUser(dir, "alice"), inner)
+			},
+			expected:   []string{"alice@example.com", "bob@example.com"},
+			incomplete: true,
+		},
+		{
+			name: "depth limit with a shorter path",
+			setup: func(dir *fakeDirectory, source *ldapSource) string {
+				source.maxDepth = 1
+				shared := addGroup(dir, "shared", addUser(dir, "carol"))
+				inner := addGroup(dir, "team", addUser(dir, "bob"), shared)
+				return addGroup(dir, "cfroles__org__orgmanager", inner, shared)
+			},
+			expected:   []string{"bob@example.com", "carol@example.com"},
+			incomplete: true,
+		},
+		{
+			name: "unreadable member",
+			setup: func(dir *fakeDirectory, source *ldapSource) string {
+				return addGroup(dir, "cfroles__org__orgmanager", addUser(dir, "alice"), "uid=gone,ou=people,dc=example,dc=com")
+			},
+			expected:   []string{"alice@example.com"},
+			incomplete: true,
+		},
+		{
+			name: "ranged member attribute",
+			setup: func(dir *fakeDirectory, source *ldapSource) string {
+				dir.maxValues = 2
+				var users []string
+				for _, name := range []string{"alice", "bob", "carol", "dave", "erin"} {
+					users = append(users, addUser(dir, name))
+				}
+				return addGroup(dir, "cfroles__org__orgmanager", users...)
+			},
+			expected: []string{"alice@example.com", "bob@example.com", "carol@example.com", "dave@example.com", "erin@example.com"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := newFakeDirectory()
+			source := newTestLdapSource(dir)
+			groupDn := test.setup(dir, source)
+			members, err := source.ListMembers(SourceGroup{Name: "cfroles__org__orgmanager", ID: groupDn})
+			if test.incomplete != isIncompleteListing(err) || (err != nil && !isIncompleteListing(err)) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if emails := memberEmails(members); strings.Join(emails, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("expected %v, got %v", test.expected, emails)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Declaration of environment variable key names
+const EnvLeaderElection string = "LEADERELECTION"
+const EnvLeaderLeaseFile string = "LEADERLEASEFILE"
+const EnvLeaderLeaseDuration string = "LEADERLEASEDURATION"
+const EnvCfInstanceIndex string = "CF_INSTANCE_INDEX"
+const EnvCfInstanceGuid string = "CF_INSTANCE_GUID"
+
+// Makes sure only one instance of the app syncs, while the other instances stand by
+type leaderElector interface {
+	// Tries to become or stay the leader. Returns true when this instance is the leader
+	acquire() (bool, error)
+	// Gives up the leadership, so another instance can take over right away
+	release() error
+}
+
+// Creates the leaderElector configured with the LEADERELECTION environment variable
+func newLeaderElector() (leaderElector, error) {
+	switch getEnvOrDefault(EnvLeaderElection, "instanceindex") {
+	case "none":
+		return noLeaderElector{}, nil
+	case "instanceindex":
+		return newInstanceIndexElector()
+	case "leasefile":
+		if os.Getenv(EnvLeaderLeaseFile) == "" {
+			return nil, errors.New("Environment variable " + EnvLeaderLeaseFile + " must be set")
+		}
+		return newLeaseElector(&fileLeaseStore{path: os.Getenv(EnvLeaderLeaseFile)})
+	default:
+		return nil, errors.New("Not a valid leader election in " + EnvLeaderElection + ": '" + os.Getenv(EnvLeaderElection) + "'")
+	}
+}
+
+// leaderElector for running a single instance: always the leader
+type noLeaderElector struct{}
+
+func (noLeaderElector) acquire() (bool, error) { return true, nil }
+func (noLeaderElector) release() error         { return nil }
+
+// leaderElector which makes the CF app instance with index 0 the leader
+// When CF_INSTANCE_INDEX is not set (e.g. running locally), the instance is the leader
+type instanceIndexElector struct {
+	index int
+}
+
+// Creates a new instanceIndexElector using the CF_INSTANCE_INDEX environment variable
+func newInstanceIndexElector() (*instanceIndexElector, error) {
+	index, err := strconv.Atoi(getEnvOrDefault(EnvCfInstanceIndex, "0"))
+	if err != nil {
+		return nil, errors.New("Not a valid number in " + EnvCfInstanceIndex + ": '" + os.Getenv(EnvCfInstanceIndex) + "'")
+	}
+	return &instanceIndexElector{index: index}, nil
+}
+
+func (e *instanceIndexElector) acquire() (bool, error) { return e.index == 0, nil }
+func (e *instanceIndexElector) release() error         { return nil }
+
+// Storage for a lease, which can only be held by one holder at a time
+type leaseStore interface {
+	// Takes or renews the lease for the holder, unless another holder has a lease which has not expired
+	// Returns true when the holder has the lease
+	tryAcquire(holder string, duration time.Duration) (bool, error)
+	// Gives up the lease, if the holder has it
+	release(holder string) error
+}
+
+// leaderElector which makes the holder of a lease the leader
+// The leader renews the lease with every acquire. When it stops doing so, the lease expires and another instance takes over
+type leaseElector struct {
+	store    leaseStore
+	holder   string
+	duration time.Duration
+}
+
+// Creates a new leaseElector using the values from the environment variables
+// Every instance is identified by its CF_INSTANCE_GUID, or by host name and process ID when not running on CF
+func newLeaseElector(store leaseStore) (*leaseElector, error) {
+	duration, err := time.ParseDuration(getEnvOrDefault(EnvLeaderLeaseDuration, "15m"))
+	if err != nil || duration <= 0 {
+		return nil, errors.New("Not a valid duration in " + EnvLeaderLeaseDuration + ": '" + os.Getenv(EnvLeaderLeaseDuration) + "'")
+	}
+	holder := os.Getenv(EnvCfInstanceGuid)
+	if holder == "" {
+		hostname, _ := os.Hostname()
+		holder = hostname + "-" + strconv.Itoa(os.Getpid())
+	}
+	return &leaseElector{store: store, holder: holder, duration: duration}, nil
+}
+
+func (e *leaseElector) acquire() (bool, error) {
+	return e.store.tryAcquire(e.holder, e.duration)
+}
+
+func (e *leaseElector) release() error {
+	return e.store.release(e.holder)
+}
+
+// A lease held by a single holder until it expires
+type lease struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
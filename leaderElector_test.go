@@ -0,0 +1,214 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// leaseStore which keeps the lease in memory
+// Stand-in for a lock in a backing service, for running several electors within one process
+type memoryLeaseStore struct {
+	mu    sync.Mutex
+	lease lease
+}
+
+func (s *memoryLeaseStore) tryAcquire(holder string, duration time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.lease.Holder != holder && s.lease.Holder != "" && now.Before(s.lease.Expires) {
+		return false, nil
+	}
+	s.lease = lease{Holder: holder, Expires: now.Add(duration)}
+	return true, nil
+}
+
+func (s *memoryLeaseStore) release(holder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lease.Holder == holder {
+		s.lease = lease{}
+	}
+	return nil
+}
+
+// Sets the environment variable, and returns a function which restores its previous value
+func setEnv(key, value string) func() {
+	previous, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestNewLeaderElector(t *testing.T) {
+	tests := []struct {
+		election  string
+		leaseFile string
+		index     string
+		leader    bool
+		invalid   bool
+	}{
+		{election: "", index: "0", leader: true},
+		{election: "instanceindex", index: "1", leader: false},
+		{election: "instanceindex", index: "x", invalid: true},
+		{election: "none", index: "1", leader: true},
+		{election: "leasefile", leaseFile: "lease", leader: true},
+		{election: "leasefile", invalid: true},
+		// The in-memory lease is only a stand-in for tests, every instance would be the leader
+		{election: "memory", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.election+"/"+test.index+test.leaseFile, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "gmapper")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			leaseFile := test.leaseFile
+			if leaseFile != "" {
+				leaseFile = filepath.Join(dir, leaseFile)
+			}
+			defer setEnv(EnvLeaderElection, test.election)()
+			defer setEnv(EnvLeaderLeaseFile, leaseFile)()
+			defer setEnv(EnvCfInstanceIndex, test.index)()
+			elector, err := newLeaderElector()
+			if test.invalid {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			leader, err := elector.acquire()
+			if err != nil || leader != test.leader {
+				t.Errorf("expected leader %v, got %v (%v)", test.leader, leader, err)
+			}
+		})
+	}
+}
+
+// Runs the same scenarios against every leaseStore
+func testLeaseStore(t *testing.T, newStore func() leaseStore) {
+	t.Run("single leader", func(t *testing.T) {
+		store := newStore()
+		a := &leaseElector{store: store, holder: "a", duration: time.Minute}
+		b := &leaseElector{store: store, holder: "b", duration: time.Minute}
+		expectLeader(t, a, true)
+		expectLeader(t, b, false)
+		// The leader renews its lease
+		expectLeader(t, a, true)
+		expectLeader(t, b, false)
+	})
+	t.Run("release hands over", func(t *testing.T) {
+		store := newStore()
+		a := &leaseElector{store: store, holder: "a", duration: time.Minute}
+		b := &leaseElector{store: store, holder: "b", duration: time.Minute}
+		expectLeader(t, a, true)
+		// Only the holder can release the lease
+		if err := b.release(); err != nil {
+			t.Fatal(err)
+		}
+		expectLeader(t, b, false)
+		if err := a.release(); err != nil {
+			t.Fatal(err)
+		}
+		expectLeader(t, b, true)
+		expectLeader(t, a, false)
+	})
+	t.Run("expired lease is taken over", func(t *testing.T) {
+		store := newStore()
+		a := &leaseElector{store: store, holder: "a", duration: 20 * time.Millisecond}
+		b := &leaseElector{store: store, holder: "b", duration: time.Minute}
+		expectLeader(t, a, true)
+		expectLeader(t, b, false)
+		time.Sleep(50 * time.Millisecond)
+		expectLeader(t, b, true)
+		expectLeader(t, a, false)
+	})
+	t.Run("concurrent electors", func(t *testing.T) {
+		store := newStore()
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		leaders := 0
+		for _, holder := range []string{"a", "b", "c", "d", "e"} {
+			wg.Add(1)
+			go func(holder string) {
+				defer wg.Done()
+				leader, err := (&leaseElector{store: store, holder: holder, duration: time.Minute}).acquire()
+				if err != nil {
+					t.Error(err)
+				}
+				if leader {
+					mu.Lock()
+					leaders++
+					mu.Unlock()
+				}
+			}(holder)
+		}
+		wg.Wait()
+		if leaders != 1 {
+			t.Errorf("expected 1 leader, got %d", leaders)
+		}
+	})
+}
+
+func expectLeader(t *testing.T, elector *leaseElector, expected bool) {
+	t.Helper()
+	leader, err := elector.acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leader != expected {
+		t.Errorf("expected leader %v for '%v', got %v", expected, elector.holder, leader)
+	}
+}
+
+func TestMemoryLeaseStore(t *testing.T) {
+	testLeaseStore(t, func() leaseStore { return &memoryLeaseStore{} })
+}
+
+func TestFileLeaseStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gmapper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := 0
+	testLeaseStore(t, func() leaseStore {
+		files++
+		return &fileLeaseStore{path: filepath.Join(dir, "lease"+strconv.Itoa(files))}
+	})
+	t.Run("stale lock file", func(t *testing.T) {
+		store := &fileLeaseStore{path: filepath.Join(dir, "stale")}
+		lock := store.path + ".lock"
+		if err := ioutil.WriteFile(lock, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		old := time.Now().Add(-2 * time.Minute)
+		if err := os.Chtimes(lock, old, old); err != nil {
+			t.Fatal(err)
+		}
+		expectLeader(t, &leaseElector{store: store, holder: "a", duration: time.Minute}, true)
+	})
+	t.Run("locked by another instance", func(t *testing.T) {
+		store := &fileLeaseStore{path: filepath.Join(dir, "locked")}
+		if err := ioutil.WriteFile(store.path+".lock", nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.tryAcquire("a", time.Minute); err == nil {
+			t.Error("expected an error while the lease file is locked")
+		}
+	})
+}
@@ -0,0 +1,21 @@
+package main
+
+// Returns the role targets which are managed after this sync cycle, by role target key
+// These are the roles which are bound to a group, and the roles which are not bound anymore but still have
+// SSO users who lose the role. Those are kept until their users are gone, e.g. when the revocations were
+// blocked, protected or pending, or when the role users could not be listed
+func managedTargets(desired map[string]*desiredTarget, actual *actualState) map[string]roleTarget {
+	managed := make(map[string]roleTarget)
+	for key, d := range desired {
+		if !d.revoke {
+			continue
+		}
+		roleUsers := actual.roleUsers[key]
+		if d.unbound && d.complete && roleUsers != nil && len(revokedUsers(d, roleUsers)) == 0 {
+			// Nobody loses the role anymore, so it is left alone from now on
+			continue
+		}
+		managed[key] = d.target
+	}
+	return managed
+}
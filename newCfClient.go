@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/SpringerPE/cf-user-role-syncher/cfclient"
+	"github.com/SpringerPE/cf-user-role-syncher/ratelimit"
+	"github.com/SpringerPE/cf-user-role-syncher/retry"
+	"github.com/SpringerPE/cf-user-role-syncher/token"
+)
+
+// Declaration of environment variable key names
+const EnvCfApiVersion string = "CFAPIVERSION"
+const EnvCfResultsPerPage string = "CFRESULTSPERPAGE"
+
+// The maximum number of results per page the CF API accepts, by API version
+var maxResultsPerPage = map[string]int{"v2": 100, "v3": 5000}
+
+// Creates the client for the CF API and UAA using the values from the environment variables
+// When no CF API version is configured, the version is detected from the API root
+func newCfClient() (*cfclient.Client, error) {
+	client := cfclient.New(os.Getenv(EnvCfApiEndPoint), os.Getenv(token.EnvUaaEndPoint), token.GetCfAccessToken)
+	perPage, err := strconv.Atoi(getEnvOrDefault(EnvCfResultsPerPage, "100"))
+	if err != nil || perPage < 1 {
+		return nil, errors.New("Not a valid number in " + EnvCfResultsPerPage + ": '" + os.Getenv(EnvCfResultsPerPage) + "'")
+	}
+	client.PerPage = perPage
+	// Every attempt waits for the rate limiter of the backend
+	apiUrl, err := url.Parse(client.ApiEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	uaaUrl, err := url.Parse(client.UaaEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	limitTransport := &ratelimit.Transport{
+		Limiters: map[string]*ratelimit.Limiter{
+			apiUrl.Host: cfRateLimiter,
+			uaaUrl.Host: uaaRateLimiter,
+		},
+	}
+	// Retry failed CF and UAA requests. Creating users (POST) is not replayed blindly
+	transport, err := newRetryTransport(limitTransport, retry.IdempotentMethod)
+	if err != nil {
+		return nil, err
+	}
+	client.HttpClient = &http.Client{Transport: transport}
+	// Requesting a new Access Token can safely be retried
+	tokenTransport, err := newRetryTransport(&ratelimit.Transport{Limiter: uaaRateLimiter}, retry.Always)
+	if err != nil {
+		return nil, err
+	}
+	token.HttpClient = &http.Client{Transport: tokenTransport}
+	switch os.Getenv(EnvCfApiVersion) {
+	case "v2", "v3":
+		client.ApiVersion = os.Getenv(EnvCfApiVersion)
+	case "":
+		client.ApiVersion, err = client.DetectApiVersion()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("Not a valid CF API version in " + EnvCfApiVersion + ": '" + os.Getenv(EnvCfApiVersion) + "'")
+	}
+	// Larger pages are rejected by the CF API
+	if max := maxResultsPerPage[client.ApiVersion]; client.PerPage > max {
+		return nil, errors.New(EnvCfResultsPerPage + " must be at most " + strconv.Itoa(max) + " for the CF API " + client.ApiVersion + ": '" + os.Getenv(EnvCfResultsPerPage) + "'")
+	}
+	log.Println("Using CF API " + client.ApiVersion)
+	return client, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestResultsPerPage(t *testing.T) {
+	defer setEnv(EnvCfApiEndPoint, "https://api.example.com")()
+	tests := []struct {
+		name       string
+		apiVersion string
+		perPage    string
+		invalid    bool
+	}{
+		{name: "default", apiVersion: "v2"},
+		{name: "v2 maximum", apiVersion: "v2", perPage: "100"},
+		{name: "over the v2 maximum", apiVersion: "v2", perPage: "101", invalid: true},
+		{name: "v3 maximum", apiVersion: "v3", perPage: "5000"},
+		{name: "over the v3 maximum", apiVersion: "v3", perPage: "5001", invalid: true},
+		{name: "zero", apiVersion: "v3", perPage: "0", invalid: true},
+		{name: "not a number", apiVersion: "v3", perPage: "all", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer setEnv(EnvCfApiVersion, test.apiVersion)()
+			defer setEnv(EnvCfResultsPerPage, test.perPage)()
+			client, err := newCfClient()
+			if test.invalid {
+				if err == nil {
+					t.Errorf("expected an error, got %d results per page", client.PerPage)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// Declaration of environment variable key names
+const EnvMembershipSource string = "MEMBERSHIPSOURCE"
+
+// Creates the MembershipSource as configured by the MEMBERSHIPSOURCE environment variable
+// This can be a comma separated list of sources, in order of precedence (e.g. "google,file")
+// When nothing is configured, Google Groups is used as source
+func newMembershipSource() (MembershipSource, error) {
+	var sources []namedSource
+	for _, name := range strings.Split(getEnvOrDefault(EnvMembershipSource, "google"), ",") {
+		name = strings.TrimSpace(name)
+		for _, s := range sources {
+			if s.name == name {
+				return nil, errors.New("Membership source '" + name + "' is configured more than once")
+			}
+		}
+		source, err := newSingleMembershipSource(name)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, namedSource{name: name, source: source})
+	}
+	// The sources are always combined by a compositeSource, even when there is only one
+	// This way every membership records the source which granted it
+	return newCompositeSource(sources), nil
+}
+
+// Creates a single MembershipSource by its name
+func newSingleMembershipSource(name string) (MembershipSource, error) {
+	switch name {
+	case "google":
+		source, err := newGoogleSource()
+		if err != nil {
+			return nil, err
+		}
+		return source, nil
+	case "ldap":
+		source, err := newLdapSource()
+		if err != nil {
+			return nil, err
+		}
+		return source, nil
+	case "file":
+		source, err := newFileSource()
+		if err != nil {
+			return nil, err
+		}
+		return source, nil
+	case "scim":
+		source, err := newScimSource()
+		if err != nil {
+			return nil, err
+		}
+		return source, nil
+	case "github":
+		source, err := newGithubSource()
+		if err != nil {
+			return nil, err
+		}
+		return source, nil
+	default:
+		return nil, errors.New("Unknown membership source '" + name + "'")
+	}
+}
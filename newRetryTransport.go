@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/SpringerPE/cf-user-role-syncher/retry"
+)
+
+// Declaration of environment variable key names
+const EnvRetryMaxAttempts string = "RETRYMAXATTEMPTS"
+const EnvRetryBaseDelay string = "RETRYBASEDELAY"
+const EnvRetryMaxDelay string = "RETRYMAXDELAY"
+
+// Creates a transport which retries failed requests, using the values from the environment variables
+// Every attempt is sent with the base transport
+// idempotent decides which requests may be sent again after they may have been processed (see retry.Transport)
+func newRetryTransport(base http.RoundTripper, idempotent func(req *http.Request) bool) (*retry.Transport, error) {
+	maxAttempts, err := strconv.Atoi(getEnvOrDefault(EnvRetryMaxAttempts, "5"))
+	if err != nil || maxAttempts < 1 {
+		return nil, errors.New("Not a valid number in " + EnvRetryMaxAttempts + ": '" + os.Getenv(EnvRetryMaxAttempts) + "'")
+	}
+	baseDelay, err := time.ParseDuration(getEnvOrDefault(EnvRetryBaseDelay, "500ms"))
+	if err != nil {
+		return nil, errors.New("Not a valid duration in " + EnvRetryBaseDelay + ": " + err.Error())
+	}
+	maxDelay, err := time.ParseDuration(getEnvOrDefault(EnvRetryMaxDelay, "30s"))
+	if err != nil {
+		return nil, errors.New("Not a valid duration in " + EnvRetryMaxDelay + ": " + err.Error())
+	}
+	return &retry.Transport{
+		Base:        base,
+		MaxAttempts: maxAttempts,
+		BaseDelay:   baseDelay,
+		MaxDelay:    maxDelay,
+		Idempotent:  idempotent,
+	}, nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/SpringerPE/cf-user-role-syncher/cfclient"
+)
+
+// The actions of plan operations
+const (
+	ActionCreateUser    string = "create-user"
+	ActionAssociate     string = "associate"
+	ActionAssign        string = "assign"
+	ActionUnassign      string = "unassign"
+	ActionRemoveFromOrg string = "remove-from-org"
+)
+
+// The actions in the order they are applied
+// Users must exist before they are associated with an org, and associated before they get a space role
+// Users are only removed from an org after all their roles in the org are unset
+var planActions = []string{ActionCreateUser, ActionAssociate, ActionAssign, ActionUnassign, ActionRemoveFromOrg}
+
+// A single change in CF/UAA
+type planOp struct {
+	Action string `json:"action"`
+	User   string `json:"user"`
+	Org    string `json:"org,omitempty"`
+	Space  string `json:"space,omitempty"`
+	Role   string `json:"role,omitempty"`
+	// The sources which grant the role (assign only)
+	Sources []string `json:"sources,omitempty"`
+	// Empty when the user is created by the plan
+	userGuid  string
+	orgGuid   string
+	spaceGuid string
+	cfRole    cfclient.Role
+}
+
+// The changes which make the actual state in CF equal to the desired state
+type syncPlan struct {
+	Ops []planOp `json:"operations"`
+	// Operations which are not applied because they exceed the revocation limits
+	Blocked []planOp `json:"blocked,omitempty"`
+	// Operations which are not applied because the user or role is protected
+	Protected []planOp `json:"protected,omitempty"`
+	// Operations which are not applied yet because the grace period has not passed
+	Pending []planOp `json:"pending,omitempty"`
+}
+
+// Returns the changes needed to get from the actual to the desired state
+// Roles which users already have are not assigned again. Roles are only unset for SSO users, and only for
+// roles a group is bound to and for which all group members are known. Users are removed from an org
+// when they don't have any role left in it
+func buildPlan(desired map[string]*desiredTarget, actual *actualState) *syncPlan {
+	plan := &syncPlan{}
+	// Users who have a role in the org after the plan is applied, by org GUID and lowercase username
+	orgMembers := make(map[string]map[string]bool)
+	// Roles which are unset by the plan, by role target key and user GUID
+	unassigned := make(map[string]bool)
+	created := make(map[string]bool)
+	associated := make(map[string]bool)
+	for _, key := range sortedTargetKeys(desired) {
+		d := desired[key]
+		target := d.target
+		if orgMembers[target.OrgGuid] == nil {
+			orgMembers[target.OrgGuid] = make(map[string]bool)
+		}
+		for username, m := range d.members {
+			orgMembers[target.OrgGuid][username] = true
+			guid, ok := actual.userGuids[username]
+			if !ok && !actual.missingUsers[username] {
+				// The user could not be searched in UAA
+				continue
+			}
+			if !ok && !created[username] {
+				created[username] = true
+				plan.Ops = append(plan.Ops, planOp{Action: ActionCreateUser, User: username})
+			}
+			// Make sure the user is associated with the org. When setting an org role this is actually
+			// not really necessary, but for setting space roles it is! If not, you'll receive an
+			// "error_code": "CF-InvalidRelation", "code": 1002 when setting the space role
+			// The org users are nil when they could not be listed, then the user is always associated
+			if _, ok := actual.orgUsers[target.OrgGuid][username]; !ok && !associated[target.OrgGuid+"/"+username] {
+				associated[target.OrgGuid+"/"+username] = true
+				plan.Ops = append(plan.Ops, planOp{Action: ActionAssociate, User: username, Org: target.OrgName,
+					userGuid: guid, orgGuid: target.OrgGuid})
+			}
+			// Nothing to do when the user already has the role
+			if _, ok := actual.roleUsers[key][username]; ok {
+				continue
+			}
+			plan.Ops = append(plan.Ops, planOp{Action: ActionAssign, User: username, Org: target.OrgName, Space: target.SpaceName,
+				Role: target.Role, Sources: m.Sources, userGuid: guid, orgGuid: target.OrgGuid, spaceGuid: target.SpaceGuid, cfRole: target.CfRole})
+		}
+		for _, user := range revokedUsers(d, actual.roleUsers[key]) {
+			unassigned[key+"/"+user.GUID] = true
+			plan.Ops = append(plan.Ops, planOp{Action: ActionUnassign, User: strings.ToLower(user.Username), Org: target.OrgName, Space: target.SpaceName,
+				Role: target.Role, userGuid: user.GUID, orgGuid: target.OrgGuid, spaceGuid: target.SpaceGuid, cfRole: target.CfRole})
+		}
+	}
+	// Remove the users who lose a role from the org, when they don't have any role left in it
+	removed := make(map[string]bool)
+	for _, op := range plan.Ops {
+		if op.Action != ActionUnassign || orgMembers[op.orgGuid][op.User] || removed[op.orgGuid+"/"+op.userGuid] {
+			continue
+		}
+		roles, ok := actual.userRoles[op.userGuid]
+		if !ok {
+			// The roles of the user could not be listed
+			continue
+		}
+		// Is the user associated to this org, and are all other roles in the org unset?
+		var orgFound, otherRoles bool
+		for _, role := range roles {
+			if role.OrgGUID != op.orgGuid {
+				continue
+			}
+			if role.Type == cfclient.OrgUser {
+				orgFound = true
+				continue
+			}
+			key := roleTarget{OrgGuid: role.OrgGUID, SpaceGuid: role.SpaceGUID, CfRole: role.Type}.key()
+			if !unassigned[key+"/"+op.userGuid] {
+				// The user still has an org or space level role within this org
+				otherRoles = true
+			}
+		}
+		if orgFound && !otherRoles {
+			removed[op.orgGuid+"/"+op.userGuid] = true
+			plan.Ops = append(plan.Ops, planOp{Action: ActionRemoveFromOrg, User: op.User, Org: op.Org, userGuid: op.userGuid, orgGuid: op.orgGuid})
+		}
+	}
+	plan.sort()
+	return plan
+}
+
+// Returns the key of the role target of the operation (see roleTarget.key)
+func (op planOp) targetKey() string {
+	return roleTarget{OrgGuid: op.orgGuid, SpaceGuid: op.spaceGuid, CfRole: op.cfRole}.key()
+}
+
+// Sorts the operations in the order they are applied, and then by org, space, role and user
+func (p *syncPlan) sort() {
+	order := make(map[string]int)
+	for i, action := range planActions {
+		order[action] = i
+	}
+	sort.SliceStable(p.Ops, func(i, j int) bool {
+		a, b := p.Ops[i], p.Ops[j]
+		if a.Action != b.Action {
+			return order[a.Action] < order[b.Action]
+		}
+		if a.Org != b.Org {
+			return a.Org < b.Org
+		}
+		if a.Space != b.Space {
+			return a.Space < b.Space
+		}
+		if a.Role != b.Role {
+			return a.Role < b.Role
+		}
+		return a.User < b.User
+	})
+}
+
+// Returns the operations with the action
+func (p *syncPlan) opsFor(action string) []planOp {
+	var ops []planOp
+	for _, op := range p.Ops {
+		if op.Action == action {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// Removes the unassign operations for which skip returns true from the plan, together with the remove-from-org
+// operations which depend on them: users can't be removed from an org when one of their roles in it is not unset
+// skip is also called for the remove-from-org operations
+// Returns the removed operations
+func (p *syncPlan) withholdUnassigns(skip func(op planOp) bool) []planOp {
+	skippedUsers := make(map[string]bool)
+	for _, op := range p.Ops {
+		if op.Action == ActionUnassign && skip(op) {
+			skippedUsers[op.orgGuid+"/"+op.userGuid] = true
+		}
+	}
+	var ops, withheld []planOp
+	for _, op := range p.Ops {
+		switch {
+		case op.Action == ActionUnassign && skip(op):
+			withheld = append(withheld, op)
+		case op.Action == ActionRemoveFromOrg && (skippedUsers[op.orgGuid+"/"+op.userGuid] || skip(op)):
+			withheld = append(withheld, op)
+		default:
+			ops = append(ops, op)
+		}
+	}
+	p.Ops = ops
+	return withheld
+}
+
+// Returns the number of operations with the action
+func countAction(ops []planOp, action string) int {
+	count := 0
+	for _, op := range ops {
+		if op.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// Logs every operation of the plan
+func (p *syncPlan) log() {
+	if len(p.Ops) == 0 && len(p.Blocked) == 0 && len(p.Protected) == 0 && len(p.Pending) == 0 {
+		log.Println("Plan: nothing to change.")
+		return
+	}
+	var counts []string
+	for _, action := range planActions {
+		if n := len(p.opsFor(action)); n > 0 {
+			counts = append(counts, strconv.Itoa(n)+" "+action)
+		}
+	}
+	log.Printf("Plan: %d operations (%v)\n", len(p.Ops), strings.Join(counts, ", "))
+	for _, op := range p.Ops {
+		log.Println("PLAN: " + op.describe())
+	}
+	for _, op := range p.Protected {
+		log.Println("PROTECTED: " + op.describe())
+	}
+	for _, op := range p.Blocked {
+		log.Println("BLOCKED: " + op.describe())
+	}
+}
+
+// Returns a readable description of the operation
+func (op planOp) describe() string {
+	place := op.Org
+	if op.Space != "" {
+		place += "/" + op.Space
+	}
+	switch op.Action {
+	case ActionCreateUser:
+		return "create user '" + op.User + "'"
+	case ActionAssociate:
+		return "associate user '" + op.User + "' to org " + op.Org
+	case ActionAssign:
+		return "assign role '" + op.Role + "' in " + place + " to user '" + op.User + "' (granted by " + strings.Join(op.Sources, ", ") + ")"
+	case ActionUnassign:
+		return "unset role '" + op.Role + "' in " + place + " for user '" + op.User + "' (not granted by any source anymore)"
+	case ActionRemoveFromOrg:
+		return "remove user '" + op.User + "' from org " + op.Org
+	}
+	return op.Action + " '" + op.User + "'"
+}
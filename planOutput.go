@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Formats in which a dry run prints the plan
+const (
+	OutputText string = "text"
+	OutputJson string = "json"
+)
+
+// Headings of the actions in the text output
+var planActionHeadings = map[string]string{
+	ActionCreateUser:    "Users to create",
+	ActionAssociate:     "Users to associate with an org",
+	ActionAssign:        "Roles to assign",
+	ActionUnassign:      "Roles to revoke",
+	ActionRemoveFromOrg: "Users to remove from an org",
+}
+
+// Writes the plan in the format (OutputText or OutputJson)
+func (p *syncPlan) write(w io.Writer, format string) error {
+	switch format {
+	case OutputText:
+		return p.writeText(w)
+	case OutputJson:
+		return p.writeJson(w)
+	}
+	return errors.New("Unknown output format '" + format + "'")
+}
+
+// Writes the operations of the plan grouped by action, one operation per line
+func (p *syncPlan) writeText(w io.Writer) error {
+	if len(p.Ops) == 0 && len(p.Blocked) == 0 && len(p.Protected) == 0 && len(p.Pending) == 0 {
+		_, err := fmt.Fprintln(w, "Nothing to change.")
+		return err
+	}
+	for _, action := range planActions {
+		ops := p.opsFor(action)
+		if len(ops) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%v (%d):\n", planActionHeadings[action], len(ops)); err != nil {
+			return err
+		}
+		for _, op := range ops {
+			if _, err := fmt.Fprintln(w, "  "+op.describe()); err != nil {
+				return err
+			}
+		}
+	}
+	for _, withheld := range []struct {
+		heading string
+		ops     []planOp
+	}{{"Protected, not revoked", p.Protected}, {"Pending, revoked after the grace period", p.Pending}, {"Blocked by the revocation limits", p.Blocked}} {
+		if len(withheld.ops) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%v (%d):\n", withheld.heading, len(withheld.ops)); err != nil {
+			return err
+		}
+		for _, op := range withheld.ops {
+			if _, err := fmt.Fprintln(w, "  "+op.describe()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Writes the plan as json document, with the number of operations per action
+func (p *syncPlan) writeJson(w io.Writer) error {
+	counts := make(map[string]int)
+	for _, action := range planActions {
+		counts[action] = len(p.opsFor(action))
+	}
+	ops := p.Ops
+	if ops == nil {
+		// Always write a list, also when there is nothing to change
+		ops = []planOp{}
+	}
+	out, err := json.MarshalIndent(struct {
+		Counts    map[string]int `json:"counts"`
+		Ops       []planOp       `json:"operations"`
+		Blocked   []planOp       `json:"blocked,omitempty"`
+		Protected []planOp       `json:"protected,omitempty"`
+		Pending   []planOp       `json:"pending,omitempty"`
+	}{counts, ops, p.Blocked, p.Protected, p.Pending}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(out))
+	return err
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/SpringerPE/cf-user-role-syncher/cfclient"
+	"github.com/SpringerPE/cf-user-role-syncher/token"
+)
+
+// Role targets in the org 'org' used by the plan tests
+var (
+	orgManager    = roleTarget{OrgGuid: "org-1", OrgName: "org", Role: "orgmanager", CfRole: cfclient.OrgManager}
+	orgAuditor    = roleTarget{OrgGuid: "org-1", OrgName: "org", Role: "auditor", CfRole: cfclient.OrgAuditor}
+	liveDeveloper = roleTarget{OrgGuid: "org-1", OrgName: "org", SpaceGuid: "space-live", SpaceName: "live", Role: "spacedeveloper", CfRole: cfclient.SpaceDeveloper}
+	devDeveloper  = roleTarget{OrgGuid: "org-1", OrgName: "org", SpaceGuid: "space-dev", SpaceName: "dev", Role: "spacedeveloper", CfRole: cfclient.SpaceDeveloper}
+)
+
+// Returns the desired target for a role which is bound to a group with the members, which are all known
+func desiredFor(target roleTarget, members ...string) *desiredTarget {
+	d := &desiredTarget{target: target, members: make(map[string]*desiredMember), revoke: true, complete: true}
+	for _, m := range members {
+		d.members[m] = &desiredMember{Email: m, Sources: []string{"google"}}
+	}
+	return d
+}
+
+// Returns the desired state with the targets
+func desiredState(targets ...*desiredTarget) map[string]*desiredTarget {
+	desired := make(map[string]*desiredTarget)
+	for _, d := range targets {
+		desired[d.target.key()] = d
+	}
+	return desired
+}
+
+// Returns the SSO users with the names, by name. The GUID of a user is 'guid-<name>'
+func ssoUsers(names ...string) map[string]cfclient.User {
+	users := make(map[string]cfclient.User)
+	for _, name := range names {
+		users[name] = cfclient.User{GUID: "guid-" + name, Username: name, Origin: "sso"}
+	}
+	return users
+}
+
+// Returns an actual state in which all the users exist and are associated with the org, and nobody has a role yet
+func actualFor(users ...string) *actualState {
+	actual := &actualState{
+		roleUsers:    make(map[string]map[string]cfclient.User),
+		orgUsers:     map[string]map[string]cfclient.User{"org-1": ssoUsers(users...)},
+		userGuids:    make(map[string]string),
+		missingUsers: make(map[string]bool),
+		userRoles:    make(map[string][]cfclient.UserRole),
+	}
+	for _, user := range users {
+		actual.userGuids[user] = "guid-" + user
+	}
+	for _, target := range []roleTarget{orgManager, orgAuditor, liveDeveloper, devDeveloper} {
+		actual.roleUsers[target.key()] = ssoUsers()
+	}
+	return actual
+}
+
+// Returns the roles of a user in the org 'org', including the association with the org
+func orgRoles(targets ...roleTarget) []cfclient.UserRole {
+	roles := []cfclient.UserRole{{Type: cfclient.OrgUser, OrgGUID: "org-1"}}
+	for _, target := range targets {
+		roles = append(roles, cfclient.UserRole{Type: target.CfRole, OrgGUID: target.OrgGuid, SpaceGUID: target.SpaceGuid})
+	}
+	return roles
+}
+
+// Returns the operations as short strings like "assign alice org/live spacedeveloper"
+func describeOps(ops []planOp) []string {
+	var described []string
+	for _, op := range ops {
+		place := op.Org
+		if op.Space != "" {
+			place += "/" + op.Space
+		}
+		described = append(described, strings.TrimSpace(op.Action+" "+op.User+" "+place+" "+op.Role))
+	}
+	return described
+}
+
+func expectOps(t *testing.T, kind string, ops []planOp, expected []string) {
+	t.Helper()
+	if actual := describeOps(ops); strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected %v operations:\n%v\ngot:\n%v", kind, strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+func TestBuildPlan(t *testing.T) {
+	defer setEnv(token.EnvUaaSsoProvider, "sso")()
+	tests := []struct {
+		name     string
+		desired  map[string]*desiredTarget
+		actual   func() *actualState
+		expected []string
+	}{
+		{
+			name:     "assign missing role",
+			desired:  desiredState(desiredFor(orgManager, "alice")),
+			actual:   func() *actualState { return actualFor("alice") },
+			expected: []string{"assign alice org orgmanager"},
+		},
+		{
+			name:    "no assign when the role already exists",
+			desired: desiredState(desiredFor(orgManager, "alice"), desiredFor(liveDeveloper, "alice")),
+			actual: func() *actualState {
+				a := actualFor("alice")
+				a.roleUsers[orgManager.key()] = ssoUsers("alice")
+				a.roleUsers[liveDeveloper.key()] = ssoUsers("alice")
+				return a
+			},
+		},
+		{
+			name:    "create and associate a new user",
+			desired: desiredState(desiredFor(liveDeveloper, "bob")),
+			actual: func() *actualState {
+				a := actualFor()
+				a.missingUsers["bob"] = true
+				return a
+			},
+			expected: []string{"create-user bob", "associate bob org", "assign bob org/live spacedeveloper"},
+		},
+		{
+			name:    "user who could not be searched",
+			desired: desiredState(desiredFor(liveDeveloper, "bob")),
+			actual:  func() *actualState { return actualFor() },
+		},
+		{
+			name:    "role users which could not be listed",
+			desired: desiredState(desiredFor(orgManager, "alice")),
+			actual: func() *actualState {
+				a := actualFor("alice")
+				a.roleUsers[orgManager.key()] = nil
+				return a
+			},
+			expected: []string{"assign alice org orgmanager"},
+		},
+		{
+			name:    "unassign and remove from org when no role is left in the org",
+			desired: desiredState(desiredFor(orgManager, "alice")),
+			actual: func() *actualState {
+				a := actualFor("alice", "bob")
+				a.roleUsers[orgManager.key()] = ssoUsers("alice", "bob")
+				a.userRoles["guid-bob"] = orgRoles(orgManager)
+				return a
+			},
+			expected: []string{"unassign bob org orgmanager", "remove-from-org bob org"},
+		},
+		{
+			name:    "no remove from org when another role in the org is left",
+			desired: desiredState(desiredFor(orgManager, "alice")),
+			actual: func() *actualState {
+				a := actualFor("alice", "bob")
+				a.roleUsers[orgManager.key()] = ssoUsers("alice", "bob")
+				a.userRoles["guid-bob"] = orgRoles(orgManager, devDeveloper)
+				return a
+			},
+			expected: []string{"unassign bob org orgmanager"},
+		},
+		{
+			name:    "remove from org when all roles in the org are unset",
+			desired: desiredState(desiredFor(orgManager, "alice"), desiredFor(liveDeveloper, "alice")),
+			actual: func() *actualState {
+				a := actualFor("alice", "bob")
+				a.roleUsers[orgManager.key()] = ssoUsers("alice", "bob")
+				a.roleUsers[liveDeveloper.key()] = ssoUsers("alice", "bob")
+				a.userRoles["guid-bob"] = orgRoles(orgManager, liveDeveloper)
+				return a
+			},
+			expected: []string{"unassign bob org orgmanager", "unassign bob org/live spacedeveloper", "remove-from-org bob org"},
+		},
+		{
+			name:    "no remove from org when another group grants a role in the org",
+			desired: desiredState(desiredFor(orgManager, "alice"), desiredFor(orgAuditor, "bob")),
+			actual: func() *actualState {
+				a := actualFor("alice", "bob")
+				a.roleUsers[orgManager.key()] = ssoUsers("alice", "bob")
+				a.roleUsers[orgAuditor.key()] = ssoUsers("bob")
+				a.userRoles["guid-bob"] = orgRoles(orgManager)
+				return a
+			},
+			expected: []string{"unassign bob org orgmanager"},
+		},
+		{
+			name:    "no remove from org when the roles of the user are unknown",
+			desired: desiredState(desiredFor(orgManager, "alice")),
+			actual: func() *actualState {
+				a := actualFor("alice", "bob")
+				a.roleUsers[orgManager.key()] = ssoUsers("alice", "bob")
+				return a
+			},
+			expected: []string{"unassign bob org orgmanager"},
+		},
+		{
+			name: "no unassign when a listing is incomplete",
+			desired: func() map[string]*desiredTarget {
+				d := desiredFor(orgManager, "alice")
+				d.complete = false
+				return desiredState(d)
+			}(),
+			actual: func() *actualState {
+				a := actualFor("alice", "bob")
+				a.roleUsers[orgManager.key()] = ssoUsers("bob")
+				a.userRoles["guid-bob"] = orgRoles(orgManager)
+				return a
+			},
+			expected: []string{"assign alice org orgmanager"},
+		},
+		{
+			name:    "no unassign for users who are not SSO users",
+			desired: desiredState(desiredFor(orgManager, "alice")),
+			actual: func() *actualState {
+				a := actualFor("alice")
+				a.roleUsers[orgManager.key()] = map[string]cfclient.User{"admin": {GUID: "guid-admin", Username: "admin", Origin: "uaa"}}
+				a.userRoles["guid-admin"] = orgRoles(orgManager)
+				return a
+			},
+			expected: []string{"assign alice org orgmanager"},
+		},
+		{
+			name: "org wide spacedeveloper only assigns",
+			desired: func() map[string]*desiredTarget {
+				live, dev := desiredFor(liveDeveloper, "alice"), desiredFor(devDeveloper, "alice")
+				live.revoke, dev.revoke = false, false
+				return desiredState(live, dev)
+			}(),
+			actual: func() *actualState {
+				a := actualFor("alice", "bob")
+				a.roleUsers[liveDeveloper.key()] = ssoUsers("bob")
+				a.roleUsers[devDeveloper.key()] = ssoUsers("alice", "bob")
+				a.userRoles["guid-bob"] = orgRoles(liveDeveloper, devDeveloper)
+				return a
+			},
+			expected: []string{"assign alice org/live spacedeveloper"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan := buildPlan(test.desired, test.actual())
+			expectOps(t, "planned", plan.Ops, test.expected)
+		})
+	}
+}
+
+// A role in the fakeCloudController
+type fakeRole struct {
+	Guid      string
+	Type      cfclient.Role
+	UserGuid  string
+	OrgGuid   string
+	SpaceGuid string
+}
+
+// In-process CF API v3 with the orgs, spaces, users and roles needed for reading the desired and actual state
+type fakeCloudController struct {
+	orgs   []cfclient.Org
+	spaces []cfclient.Space
+	users  []cfclient.User
+	roles  []fakeRole
+	// Requests for which fail returns true are answered with HTTP 500
+	fail func(r *http.Request) bool
+}
+
+// Starts the fakeCloudController and points cf to it. The returned function stops it and restores cf
+func (f *fakeCloudController) start() func() {
+	server := httptest.NewServer(f)
+	previous := cf
+	cf = cfclient.New(server.URL, server.URL, func() (string, error) { return "bearer test", nil })
+	cf.ApiVersion = "v3"
+	return func() {
+		cf = previous
+		server.Close()
+	}
+}
+
+func (f *fakeCloudController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" || (f.fail != nil && f.fail(r)) {
+		w.WriteHeader(500)
+		return
+	}
+	q := r.URL.Query()
+	// Checks a filter like 'names', which matches everything when it is not set
+	matches := func(filter string, value string) bool {
+		return q.Get(filter) == "" || containsString(strings.Split(q.Get(filter), ","), value)
+	}
+	type relationship struct {
+		Data *struct {
+			Guid string `json:"guid"`
+		} `json:"data"`
+	}
+	related := func(guid string) relationship {
+		var rel relationship
+		if guid != "" {
+			rel.Data = &struct {
+				Guid string `json:"guid"`
+			}{Guid: guid}
+		}
+		return rel
+	}
+	var resources []interface{}
+	included := make(map[string][]interface{})
+	switch r.URL.Path {
+	case "/v3/organizations":
+		for _, org := range f.orgs {
+			if matches("names", org.Name) {
+				resources = append(resources, map[string]string{"guid": org.GUID, "name": org.Name})
+			}
+		}
+	case "/v3/spaces":
+		for _, space := range f.spaces {
+			if matches("names", space.Name) && matches("organization_guids", space.OrgGUID) {
+				resources = append(resources, map[string]interface{}{"guid": space.GUID, "name": space.Name,
+					"relationships": map[string]relationship{"organization": related(space.OrgGUID)}})
+			}
+		}
+	case "/v3/roles":
+		for _, role := range f.roles {
+			if !matches("types", string(role.Type)) || !matches("user_guids", role.UserGuid) ||
+				!matches("organization_guids", role.OrgGuid) || !matches("space_guids", role.SpaceGuid) {
+				continue
+			}
+			resources = append(resources, map[string]interface{}{"guid": role.Guid, "type": role.Type,
+				"relationships": map[string]relationship{"user": related(role.UserGuid), "organization": related(role.OrgGuid), "space": related(role.SpaceGuid)}})
+			for _, user := range f.users {
+				if q.Get("include") == "user" && user.GUID == role.UserGuid {
+					included["users"] = append(included["users"], map[string]string{"guid": user.GUID, "username": user.Username, "origin": user.Origin})
+				}
+			}
+			for _, space := range f.spaces {
+				if q.Get("include") == "space" && space.GUID == role.SpaceGuid {
+					included["spaces"] = append(included["spaces"], map[string]interface{}{"guid": space.GUID, "name": space.Name,
+						"relationships": map[string]relationship{"organization": related(space.OrgGUID)}})
+				}
+			}
+		}
+	default:
+		w.WriteHeader(404)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"pagination": map[string]interface{}{"next": nil}, "resources": resources, "included": included})
+}
+
+// Returns the listing of a complete group with the members
+func listingFor(org string, space string, role string, members ...string) groupListing {
+	listing := groupListing{group: &Group{Org: org, Space: space, Role: role}, complete: true}
+	for _, m := range members {
+		listing.members = append(listing.members, Member{Email: m, Sources: []string{"google"}})
+	}
+	return listing
+}
+
+func TestPlanWithUnresolvedBindings(t *testing.T) {
+	defer setEnv(token.EnvUaaSsoProvider, "sso")()
+	tests := []struct {
+		name string
+		// Adds an org wide spacedeveloper group with alice
+		orgWide bool
+		// Requests for which the CF API fails
+		fail     func(r *http.Request) bool
+		expected []string
+	}{
+		{
+			name:     "all bindings resolved",
+			expected: []string{"unassign alice org/dev spacedeveloper"},
+		},
+		{
+			// The org wide group grants alice the role in dev, but the spaces of the org are unknown
+			name:     "spaces of the org can't be listed",
+			orgWide:  true,
+			fail:     func(r *http.Request) bool { return r.URL.Path == "/v3/spaces" && r.URL.Query().Get("names") == "" },
+			expected: nil,
+		},
+		{
+			name:     "space can't be found",
+			fail:     func(r *http.Request) bool { return r.URL.Path == "/v3/spaces" && r.URL.Query().Get("names") == "live" },
+			expected: nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeCloudController{
+				orgs:   []cfclient.Org{{GUID: "org-1", Name: "org"}},
+				spaces: []cfclient.Space{{GUID: "space-dev", Name: "dev", OrgGUID: "org-1"}, {GUID: "space-live", Name: "live", OrgGUID: "org-1"}},
+				fail:   test.fail,
+			}
+			defer fake.start()()
+			listings := []groupListing{
+				listingFor("org", "dev", "spacedeveloper", "bob"),
+				listingFor("org", "live", "spacedeveloper", "carol"),
+			}
+			if test.orgWide {
+				listings = append(listings, listingFor("org", "", "spacedeveloper", "alice"))
+			}
+			desired := buildDesiredState(listings, nil, &cycleSummary{})
+			actual := actualFor("alice", "bob", "carol")
+			actual.roleUsers[devDeveloper.key()] = ssoUsers("alice", "bob")
+			actual.roleUsers[liveDeveloper.key()] = ssoUsers("carol")
+			actual.userRoles["guid-alice"] = orgRoles(devDeveloper, orgAuditor)
+			expectOps(t, "planned", buildPlan(desired, actual).Ops, test.expected)
+		})
+	}
+}
+
+func TestPlanWithRemovedGroups(t *testing.T) {
+	defer setEnv(token.EnvUaaSsoProvider, "sso")()
+	tests := []struct {
+		name string
+		// The roles which were bound to a group in the previous cycle
+		managed []roleTarget
+		// The org wide spacedeveloper group with alice is still there
+		orgWide bool
+		// Number of groups which failed in this cycle
+		failedGroups int
+		// Requests for which the CF API fails
+		fail     func(r *http.Request) bool
+		expected []string
+		// Keys of the managed targets after the cycle
+		stillManaged []string
+	}{
+		{
+			name:         "group removed",
+			managed:      []roleTarget{orgManager, liveDeveloper},
+			expected:     []string{"unassign bob org orgmanager"},
+			stillManaged: []string{orgManager.key(), liveDeveloper.key()},
+		},
+		{
+			name:         "role without SSO users is not managed anymore",
+			managed:      []roleTarget{orgAuditor, liveDeveloper},
+			stillManaged: []string{liveDeveloper.key()},
+		},
+		{
+			// The removed group could still be bound to the role through the failed group
+			name:         "failed groups",
+			managed:      []roleTarget{orgManager, liveDeveloper},
+			failedGroups: 1,
+			stillManaged: []string{orgManager.key(), liveDeveloper.key()},
+		},
+		{
+			name:         "org with unresolved bindings",
+			managed:      []roleTarget{orgManager, liveDeveloper},
+			fail:         func(r *http.Request) bool { return r.URL.Path == "/v3/spaces" },
+			stillManaged: []string{orgManager.key(), liveDeveloper.key()},
+		},
+		{
+			name:         "role still granted by the org wide group",
+			managed:      []roleTarget{devDeveloper, liveDeveloper},
+			orgWide:      true,
+			expected:     []string{"assign alice org/live spacedeveloper", "unassign bob org/dev spacedeveloper"},
+			stillManaged: []string{devDeveloper.key(), liveDeveloper.key()},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeCloudController{
+				orgs:   []cfclient.Org{{GUID: "org-1", Name: "org"}},
+				spaces: []cfclient.Space{{GUID: "space-dev", Name: "dev", OrgGUID: "org-1"}, {GUID: "space-live", Name: "live", OrgGUID: "org-1"}},
+				fail:   test.fail,
+			}
+			defer fake.start()()
+			listings := []groupListing{listingFor("org", "live", "spacedeveloper", "carol")}
+			if test.orgWide {
+				listings = append(listings, listingFor("org", "", "spacedeveloper", "alice"))
+			}
+			managed := make(map[string]roleTarget)
+			for _, target := range test.managed {
+				managed[target.key()] = target
+			}
+			desired := buildDesiredState(listings, managed, &cycleSummary{FailedGroups: test.failedGroups})
+			actual := actualFor("alice", "bob", "carol")
+			actual.roleUsers[orgManager.key()] = ssoUsers("bob")
+			actual.roleUsers[orgAuditor.key()] = map[string]cfclient.User{}
+			actual.roleUsers[devDeveloper.key()] = ssoUsers("alice", "bob")
+			actual.roleUsers[liveDeveloper.key()] = ssoUsers("carol")
+			actual.userRoles["guid-bob"] = orgRoles(orgManager, devDeveloper)
+			expectOps(t, "planned", buildPlan(desired, actual).Ops, test.expected)
+			var keys []string
+			for key := range managedTargets(desired, actual) {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			sort.Strings(test.stillManaged)
+			if strings.Join(keys, ",") != strings.Join(test.stillManaged, ",") {
+				t.Errorf("expected managed targets %v, got %v", test.stillManaged, keys)
+			}
+		})
+	}
+}
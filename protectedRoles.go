@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Declaration of environment variable key names
+const EnvProtectedFile string = "PROTECTEDFILE"
+
+// A rule for roles which are never unset, e.g. for break-glass accounts and platform admins
+// Empty fields match everything, but a rule must at least have one field
+type protectedRule struct {
+	// Username (email address) or a pattern like "*-admin@mydomain.com" (see path.Match)
+	User  string `yaml:"user"`
+	Org   string `yaml:"org"`
+	Space string `yaml:"space"`
+	// The role name as used in group names, e.g. "orgmanager"
+	Role string `yaml:"role"`
+}
+
+// Structure of the file with protected users and roles
+type protectedFile struct {
+	Protected []protectedRule `yaml:"protected"`
+}
+
+// The rules for protected users and roles
+// Loaded by loadProtectedRules at startup. Empty when no file is configured
+var protectedRules []protectedRule
+
+// Loads the rules for protected users and roles from the file in the PROTECTEDFILE environment variable (YAML or JSON)
+func loadProtectedRules() error {
+	if os.Getenv(EnvProtectedFile) == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(os.Getenv(EnvProtectedFile))
+	if err != nil {
+		return err
+	}
+	var file protectedFile
+	if err := yaml.UnmarshalStrict(b, &file); err != nil {
+		return errors.New("Could not parse protected file '" + os.Getenv(EnvProtectedFile) + "': " + err.Error())
+	}
+	for i, rule := range file.Protected {
+		if rule.User == "" && rule.Org == "" && rule.Space == "" && rule.Role == "" {
+			return errors.New("Rule " + strconv.Itoa(i+1) + " in protected file is empty")
+		}
+		if rule.Space != "" && rule.Org == "" {
+			return errors.New("Rule " + strconv.Itoa(i+1) + " in protected file has a space, but no org")
+		}
+		// Users are matched case-insensitively
+		file.Protected[i].User = strings.ToLower(rule.User)
+		if _, err := path.Match(file.Protected[i].User, ""); err != nil {
+			return errors.New("Rule " + strconv.Itoa(i+1) + " in protected file has an invalid user pattern: " + err.Error())
+		}
+	}
+	protectedRules = file.Protected
+	return nil
+}
+
+// Returns true when the rule protects the operation
+// Unassign operations are protected when the rule matches the user, org, space and role
+// Remove-from-org operations are protected when the rule matches the user and org, and has no space and role
+func (r protectedRule) matches(op planOp) bool {
+	if r.User != "" {
+		if ok, _ := path.Match(r.User, op.User); !ok {
+			return false
+		}
+	}
+	if r.Org != "" && !strings.EqualFold(r.Org, op.Org) {
+		return false
+	}
+	if op.Action == ActionRemoveFromOrg {
+		return r.Space == "" && r.Role == ""
+	}
+	if r.Space != "" && !strings.EqualFold(r.Space, op.Space) {
+		return false
+	}
+	return r.Role == "" || strings.EqualFold(r.Role, op.Role)
+}
+
+// Moves the unassign and remove-from-org operations which are protected by a rule from the plan to the
+// protected operations, together with the remove-from-org operations which depend on them
+// Returns the number of protected revocations
+func (p *syncPlan) protect(rules []protectedRule) int {
+	if len(rules) == 0 {
+		return 0
+	}
+	withheld := p.withholdUnassigns(func(op planOp) bool {
+		for _, rule := range rules {
+			if rule.matches(op) {
+				return true
+			}
+		}
+		return false
+	})
+	p.Protected = append(p.Protected, withheld...)
+	return countAction(withheld, ActionUnassign)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestWithholdUnassigns(t *testing.T) {
+	tests := []struct {
+		name     string
+		skip     func(op planOp) bool
+		expected []string
+		withheld []string
+	}{
+		{
+			name:     "nothing skipped",
+			skip:     func(op planOp) bool { return false },
+			expected: []string{"assign carol org orgmanager", "unassign alice org orgmanager", "unassign bob org/live spacedeveloper", "remove-from-org bob org"},
+		},
+		{
+			name:     "remove from org depends on the unassigns of the user",
+			skip:     func(op planOp) bool { return op.Action == ActionUnassign && op.User == "bob" },
+			expected: []string{"assign carol org orgmanager", "unassign alice org orgmanager"},
+			withheld: []string{"unassign bob org/live spacedeveloper", "remove-from-org bob org"},
+		},
+		{
+			name:     "only remove from org skipped",
+			skip:     func(op planOp) bool { return op.Action == ActionRemoveFromOrg },
+			expected: []string{"assign carol org orgmanager", "unassign alice org orgmanager", "unassign bob org/live spacedeveloper"},
+			withheld: []string{"remove-from-org bob org"},
+		},
+		{
+			name:     "other actions are never withheld",
+			skip:     func(op planOp) bool { return true },
+			expected: []string{"assign carol org orgmanager"},
+			withheld: []string{"unassign alice org orgmanager", "unassign bob org/live spacedeveloper", "remove-from-org bob org"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan := planWith(planOp{Action: ActionAssign, User: "carol", Org: "org", Role: "orgmanager"},
+				unassignOp(orgManager, "alice"), unassignOp(liveDeveloper, "bob"), removeFromOrgOp("bob"))
+			withheld := plan.withholdUnassigns(test.skip)
+			expectOps(t, "planned", plan.Ops, test.expected)
+			expectOps(t, "withheld", withheld, test.withheld)
+		})
+	}
+}
+
+func TestProtect(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []protectedRule
+		// Number of protected revocations
+		count     int
+		expected  []string
+		protected []string
+	}{
+		{
+			name:     "no rules",
+			expected: []string{"unassign admin-bob org orgmanager", "unassign alice org/live spacedeveloper", "remove-from-org admin-bob org", "remove-from-org alice org"},
+		},
+		{
+			name:      "user pattern",
+			rules:     []protectedRule{{User: "admin-*"}},
+			count:     1,
+			expected:  []string{"unassign alice org/live spacedeveloper", "remove-from-org alice org"},
+			protected: []string{"unassign admin-bob org orgmanager", "remove-from-org admin-bob org"},
+		},
+		{
+			name:      "role in an org",
+			rules:     []protectedRule{{Org: "ORG", Role: "orgmanager"}},
+			count:     1,
+			expected:  []string{"unassign alice org/live spacedeveloper", "remove-from-org alice org"},
+			protected: []string{"unassign admin-bob org orgmanager", "remove-from-org admin-bob org"},
+		},
+		{
+			name:      "space",
+			rules:     []protectedRule{{Org: "org", Space: "live"}},
+			count:     1,
+			expected:  []string{"unassign admin-bob org orgmanager", "remove-from-org admin-bob org"},
+			protected: []string{"unassign alice org/live spacedeveloper", "remove-from-org alice org"},
+		},
+		{
+			name:      "org membership only",
+			rules:     []protectedRule{{User: "alice", Org: "org"}},
+			count:     1,
+			expected:  []string{"unassign admin-bob org orgmanager", "remove-from-org admin-bob org"},
+			protected: []string{"unassign alice org/live spacedeveloper", "remove-from-org alice org"},
+		},
+		{
+			name:     "other org",
+			rules:    []protectedRule{{Org: "other"}},
+			expected: []string{"unassign admin-bob org orgmanager", "unassign alice org/live spacedeveloper", "remove-from-org admin-bob org", "remove-from-org alice org"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan := planWith(unassignOp(orgManager, "admin-bob"), removeFromOrgOp("admin-bob"),
+				unassignOp(liveDeveloper, "alice"), removeFromOrgOp("alice"))
+			if count := plan.protect(test.rules); count != test.count {
+				t.Errorf("expected %d protected revocations, got %d", test.count, count)
+			}
+			expectOps(t, "planned", plan.Ops, test.expected)
+			expectOps(t, "protected", plan.Protected, test.protected)
+		})
+	}
+}
+
+func TestLoadProtectedRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		invalid bool
+	}{
+		{name: "valid", content: "protected:\n- user: Admin-*@Example.com\n- org: org\n  space: live\n"},
+		{name: "empty rule", content: "protected:\n- {}\n", invalid: true},
+		{name: "space without org", content: "protected:\n- space: live\n", invalid: true},
+		{name: "invalid pattern", content: "protected:\n- user: '[admin'\n", invalid: true},
+		{name: "unknown field", content: "protected:\n- username: admin\n", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := ioutil.TempFile("", "protected")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(file.Name())
+			if _, err := file.WriteString(test.content); err != nil {
+				t.Fatal(err)
+			}
+			file.Close()
+			defer setEnv(EnvProtectedFile, file.Name())()
+			protectedRules = nil
+			defer func() { protectedRules = nil }()
+			err = loadProtectedRules()
+			if test.invalid {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Users are matched case-insensitively
+			if len(protectedRules) != 2 || !protectedRules[0].matches(unassignOp(orgManager, "admin-bob@example.com")) {
+				t.Errorf("unexpected rules %+v", protectedRules)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/SpringerPE/cf-user-role-syncher/ratelimit"
+)
+
+// Declaration of environment variable key names
+const EnvRateLimitCf string = "RATELIMITCF"
+const EnvRateLimitUaa string = "RATELIMITUAA"
+const EnvRateLimitGoogle string = "RATELIMITGOOGLE"
+
+// Rate limiters per backend, shared by all requests to that backend
+// Set by loadRateLimiters
+var cfRateLimiter, uaaRateLimiter, googleRateLimiter *ratelimit.Limiter
+
+// Creates the rate limiters using the values (requests per second) from the environment variables
+func loadRateLimiters() error {
+	var err error
+	if cfRateLimiter, err = newRateLimiter("cf", EnvRateLimitCf, "20"); err != nil {
+		return err
+	}
+	if uaaRateLimiter, err = newRateLimiter("uaa", EnvRateLimitUaa, "20"); err != nil {
+		return err
+	}
+	if googleRateLimiter, err = newRateLimiter("google", EnvRateLimitGoogle, "10"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Creates a single rate limiter. Bursts are allowed up to the number of requests per second
+func newRateLimiter(name string, envKey string, def string) (*ratelimit.Limiter, error) {
+	rate, err := strconv.ParseFloat(getEnvOrDefault(envKey, def), 64)
+	if err != nil || rate < 0 {
+		return nil, errors.New("Not a valid number in " + envKey + ": '" + os.Getenv(envKey) + "'")
+	}
+	return ratelimit.NewLimiter(name, rate, int(math.Ceil(rate))), nil
+}
+
+// Logs how much every backend was throttled since the previous call
+func logRateLimiterStats() {
+	for _, limiter := range []*ratelimit.Limiter{cfRateLimiter, uaaRateLimiter, googleRateLimiter} {
+		stats := limiter.TakeStats()
+		log.Printf("Rate limit '%v': %d requests, %d throttled, %v spent throttled\n", limiter.Name, stats.Requests, stats.Throttled, stats.Waited)
+	}
+}
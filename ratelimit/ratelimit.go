@@ -0,0 +1,117 @@
+// Package ratelimit provides a token bucket rate limiter and an http.RoundTripper which uses it
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Token bucket rate limiter which can be shared by all code paths sending requests to the same backend
+// Safe for concurrent use
+type Limiter struct {
+	// Name of the backend, e.g. "cf"
+	Name  string
+	mu    sync.Mutex
+	rate  float64
+	burst float64
+	// Number of available tokens. Negative when requests are waiting for a token
+	tokens float64
+	last   time.Time
+	stats  Stats
+}
+
+// Statistics of a Limiter
+type Stats struct {
+	// Number of requests
+	Requests int64
+	// Number of requests which had to wait
+	Throttled int64
+	// Total time requests had to wait
+	Waited time.Duration
+}
+
+// Creates a new Limiter which allows rate requests per second, with bursts of at most burst requests
+// A rate of 0 or lower means no limit
+func NewLimiter(name string, rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{Name: name, rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Blocks until the request may be sent, or until the context is done
+func (l *Limiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	l.stats.Requests++
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	// Add the tokens for the time passed since the last request, up to the burst size
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	// Take a token. When there is none, wait until it is available
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+		l.stats.Throttled++
+		l.stats.Waited += delay
+	}
+	l.mu.Unlock()
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Returns the statistics since the previous call, and resets them
+func (l *Limiter) TakeStats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := l.stats
+	l.stats = Stats{}
+	return stats
+}
+
+// Transport waits for the Limiter of the backend before sending a request
+type Transport struct {
+	// The transport which sends the requests. http.DefaultTransport when nil
+	Base http.RoundTripper
+	// Limiters by host, for clients which send requests to several backends
+	Limiters map[string]*Limiter
+	// Limiter for requests to all other hosts. No limit when nil
+	Limiter *Limiter
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	limiter, ok := t.Limiters[req.URL.Host]
+	if !ok {
+		limiter = t.Limiter
+	}
+	if limiter != nil {
+		if err := limiter.Wait(req.Context()); err != nil {
+			// The request is not sent, so the body must be closed here
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, err
+		}
+	}
+	return base.RoundTrip(req)
+}
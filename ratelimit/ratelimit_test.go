@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLimiterWait(t *testing.T) {
+	// 20 requests per second, so a request waits 50ms for a token once the burst is used up
+	limiter := NewLimiter("cf", 20, 2)
+	started := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if waited := time.Since(started); waited < 90*time.Millisecond {
+		t.Errorf("expected to wait for 2 tokens, waited %v", waited)
+	}
+	stats := limiter.TakeStats()
+	if stats.Requests != 4 || stats.Throttled != 2 || stats.Waited < 90*time.Millisecond {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	// The stats are reset
+	if stats := limiter.TakeStats(); stats != (Stats{}) {
+		t.Errorf("expected the stats to be reset, got %+v", stats)
+	}
+}
+
+func TestLimiterWithoutLimit(t *testing.T) {
+	limiter := NewLimiter("cf", 0, 0)
+	for i := 0; i < 100; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := limiter.TakeStats(); stats.Requests != 100 || stats.Throttled != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestLimiterCanceled(t *testing.T) {
+	limiter := NewLimiter("cf", 0.1, 1)
+	limiter.Wait(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	// The next token is only available after 10 seconds
+	if err := limiter.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+}
+
+// RoundTripper which counts the requests
+type countingTransport struct {
+	requests int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests++
+	return &http.Response{StatusCode: 200}, nil
+}
+
+func TestTransport(t *testing.T) {
+	cf := NewLimiter("cf", 0, 0)
+	other := NewLimiter("other", 0, 0)
+	base := &countingTransport{}
+	transport := &Transport{Base: base, Limiters: map[string]*Limiter{"api.example.com": cf}, Limiter: other}
+	for _, u := range []string{"https://api.example.com/v3/roles", "https://api.example.com/v3/spaces", "https://uaa.example.com/Users"} {
+		req, _ := http.NewRequest("GET", u, nil)
+		if _, err := transport.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if base.requests != 3 || cf.TakeStats().Requests != 2 || other.TakeStats().Requests != 1 {
+		t.Errorf("expected 2 requests for cf and 1 for other hosts, got %d requests", base.requests)
+	}
+}
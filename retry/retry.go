@@ -0,0 +1,152 @@
+// Package retry provides an http.RoundTripper which retries failed requests with exponential backoff and jitter
+package retry
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Transport retries requests which failed with a transient error
+// Retried are: connection errors and the HTTP status codes 429, 500, 502, 503 and 504
+// Requests which are not idempotent (e.g. POST) are only retried when the server can't have processed them:
+// when the connection could not be made, or when the server responded with 429 (Too Many Requests)
+type Transport struct {
+	// The transport which sends the requests. http.DefaultTransport when nil
+	Base http.RoundTripper
+	// Maximum number of attempts, including the first one
+	MaxAttempts int
+	// Delay before the first retry. Doubled with every next retry
+	BaseDelay time.Duration
+	// Maximum delay between two attempts, also for the delay requested with a Retry-After header
+	MaxDelay time.Duration
+	// Decides if the request may be sent again after it may have been processed by the server
+	// IdempotentMethod when nil
+	Idempotent func(req *http.Request) bool
+}
+
+// Returns true for the HTTP methods which are idempotent according to RFC 7231
+func IdempotentMethod(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// Returns true for every request. Used for requests which are safe to replay, like requesting an Oauth token
+func Always(req *http.Request) bool {
+	return true
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	idempotent := t.Idempotent
+	if idempotent == nil {
+		idempotent = IdempotentMethod
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := base.RoundTrip(req)
+		if attempt >= t.MaxAttempts || !t.shouldRetry(req, resp, err, idempotent) {
+			return resp, err
+		}
+		// The body has already been read, so a copy is needed to send it again
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, err
+			}
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return resp, err
+			}
+			retryReq := *req
+			retryReq.Body = body
+			req = &retryReq
+		}
+		delay := t.backoff(attempt, resp)
+		if err != nil {
+			log.Printf("%v %v failed: %v. Retrying in %v (attempt %d of %d)\n", req.Method, req.URL.Host+req.URL.Path, err, delay, attempt+1, t.MaxAttempts)
+		} else {
+			log.Printf("%v %v returned HTTP status code %d. Retrying in %v (attempt %d of %d)\n", req.Method, req.URL.Host+req.URL.Path, resp.StatusCode, delay, attempt+1, t.MaxAttempts)
+			// Drain and close the body, so the connection can be reused
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// Checks if the request should be sent again, based on the response or error of the last attempt
+func (t *Transport) shouldRetry(req *http.Request, resp *http.Response, err error, idempotent func(req *http.Request) bool) bool {
+	if err != nil {
+		// Don't retry when the request was canceled on purpose
+		if req.Context().Err() != nil {
+			return false
+		}
+		return idempotent(req) || isDialError(err)
+	}
+	switch resp.StatusCode {
+	case 429:
+		return true
+	case 500, 502, 503, 504:
+		return idempotent(req)
+	}
+	return false
+}
+
+// Returns the delay before the next attempt
+// A Retry-After header of the response is honoured. Otherwise exponential backoff with full jitter is used
+func (t *Transport) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if delay, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			if delay > t.MaxDelay {
+				return t.MaxDelay
+			}
+			return delay
+		}
+	}
+	delay := t.BaseDelay << uint(attempt-1)
+	if delay > t.MaxDelay || delay <= 0 {
+		delay = t.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// Parses the value of a Retry-After header, which is either a number of seconds or an HTTP date
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// Checks if the error occurred while connecting, so the request was never sent
+func isDialError(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
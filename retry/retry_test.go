@@ -0,0 +1,142 @@
+package retry
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// RoundTripper which answers the attempts with the status codes, or with err when a status code is 0
+// The bodies of all attempts are recorded
+type fakeTransport struct {
+	codes  []int
+	err    error
+	header http.Header
+	bodies []string
+}
+
+func (f *fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := ""
+	if req.Body != nil {
+		b, _ := ioutil.ReadAll(req.Body)
+		body = string(b)
+	}
+	f.bodies = append(f.bodies, body)
+	code := f.codes[len(f.bodies)-1]
+	if code == 0 {
+		return nil, f.err
+	}
+	return &http.Response{StatusCode: code, Header: f.header, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+}
+
+// Error of a connection which could not be made
+var dialError = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+// Error of a connection which broke after the request was sent
+var readError = &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		codes  []int
+		err    error
+		// Number of attempts
+		expected int
+	}{
+		{name: "success", method: "GET", codes: []int{200}, expected: 1},
+		{name: "GET after 503", method: "GET", codes: []int{503, 200}, expected: 2},
+		{name: "GET after read error", method: "GET", codes: []int{0, 200}, err: readError, expected: 2},
+		{name: "client error", method: "GET", codes: []int{404}, expected: 1},
+		{name: "max attempts", method: "GET", codes: []int{500, 502, 504}, expected: 3},
+		// The server could have processed the request
+		{name: "POST after 500", method: "POST", codes: []int{500, 200}, expected: 1},
+		{name: "POST after read error", method: "POST", codes: []int{0, 200}, err: readError, expected: 1},
+		// The server did not process the request
+		{name: "POST after dial error", method: "POST", codes: []int{0, 201}, err: dialError, expected: 2},
+		{name: "POST after 429", method: "POST", codes: []int{429, 201}, expected: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := &fakeTransport{codes: test.codes, err: test.err}
+			transport := &Transport{Base: base, MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+			req, _ := http.NewRequest(test.method, "http://cf.example.com/v3/roles", bytes.NewReader([]byte("payload")))
+			transport.RoundTrip(req)
+			if len(base.bodies) != test.expected {
+				t.Errorf("expected %d attempts, got %d", test.expected, len(base.bodies))
+			}
+			// The body is sent again with every attempt
+			for i, body := range base.bodies {
+				if body != "payload" {
+					t.Errorf("expected the payload with attempt %d, got '%v'", i+1, body)
+				}
+			}
+		})
+	}
+}
+
+func TestRoundTripWithoutGetBody(t *testing.T) {
+	base := &fakeTransport{codes: []int{429, 201}}
+	transport := &Transport{Base: base, MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+	req, _ := http.NewRequest("POST", "http://cf.example.com/v3/roles", ioutil.NopCloser(bytes.NewReader([]byte("payload"))))
+	// The body can't be sent again, so the response is returned as it is
+	resp, err := transport.RoundTrip(req)
+	if err != nil || resp.StatusCode != 429 || len(base.bodies) != 1 {
+		t.Errorf("expected the HTTP 429 response after 1 attempt, got %v (%v) after %d attempts", resp, err, len(base.bodies))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	transport := &Transport{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	tests := []struct {
+		name       string
+		attempt    int
+		retryAfter string
+		// The delay is random between 0 and max, unless min is set
+		min time.Duration
+		max time.Duration
+	}{
+		{name: "first retry", attempt: 1, max: time.Second},
+		{name: "third retry", attempt: 3, max: 4 * time.Second},
+		{name: "capped", attempt: 10, max: 10 * time.Second},
+		{name: "retry after seconds", attempt: 1, retryAfter: "7", min: 7 * time.Second, max: 7 * time.Second},
+		// The server can't make the client wait forever
+		{name: "retry after capped", attempt: 1, retryAfter: "3600", min: 10 * time.Second, max: 10 * time.Second},
+		{name: "retry after date", attempt: 1, retryAfter: time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), min: 10 * time.Second, max: 10 * time.Second},
+		{name: "retry after date passed", attempt: 1, retryAfter: time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)},
+		{name: "invalid retry after", attempt: 1, retryAfter: "soon", max: time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: 429, Header: http.Header{}}
+			if test.retryAfter != "" {
+				resp.Header.Set("Retry-After", test.retryAfter)
+			}
+			for i := 0; i < 20; i++ {
+				if delay := transport.backoff(test.attempt, resp); delay < test.min || delay > test.max {
+					t.Fatalf("expected a delay between %v and %v, got %v", test.min, test.max, delay)
+				}
+			}
+		})
+	}
+}
+
+func TestRoundTripHonoursRetryAfter(t *testing.T) {
+	base := &fakeTransport{codes: []int{503, 200}, header: http.Header{"Retry-After": {strconv.Itoa(1)}}}
+	transport := &Transport{Base: base, MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	req, _ := http.NewRequest("GET", "http://cf.example.com/v3/roles", nil)
+	started := time.Now()
+	resp, err := transport.RoundTrip(req)
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatalf("expected HTTP 200, got %v (%v)", resp, err)
+	}
+	// Retry-After asks for 1 second, which is capped to MaxDelay
+	if waited := time.Since(started); waited < 50*time.Millisecond || waited > 900*time.Millisecond {
+		t.Errorf("expected to wait MaxDelay, waited %v", waited)
+	}
+}
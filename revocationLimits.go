@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+)
+
+// Declaration of environment variable key names
+const EnvRevocationMaxPerGroup string = "REVOCATIONMAXPERGROUP"
+const EnvRevocationMaxPerCycle string = "REVOCATIONMAXPERCYCLE"
+const EnvRevocationMaxPercent string = "REVOCATIONMAXPERCENT"
+
+// Safety limits for unsetting roles. They protect against a source which suddenly returns too few members
+// (e.g. an outage, a permission change or a group which was emptied by accident)
+// A limit of 0 disables the check. All limits are disabled by default, so roles are unset as before unless limits are set
+type revocationLimits struct {
+	// Maximum number of roles unset per Org/Space role in a single cycle
+	PerGroup int
+	// Maximum number of roles unset in a single cycle
+	PerCycle int
+	// Maximum percentage of the users with an Org/Space role who lose it in a single cycle
+	// A single revocation is always allowed, so small groups can still lose a member
+	Percent int
+}
+
+// Set by loadRevocationLimits
+var limits revocationLimits
+
+// Loads the revocation limits from the environment variables
+func loadRevocationLimits() error {
+	var err error
+	if limits.PerGroup, err = loadLimit(EnvRevocationMaxPerGroup, "0"); err != nil {
+		return err
+	}
+	if limits.PerCycle, err = loadLimit(EnvRevocationMaxPerCycle, "0"); err != nil {
+		return err
+	}
+	if limits.Percent, err = loadLimit(EnvRevocationMaxPercent, "0"); err != nil {
+		return err
+	}
+	if limits.Percent > 100 {
+		return errors.New("Not a valid percentage in " + EnvRevocationMaxPercent + ": '" + os.Getenv(EnvRevocationMaxPercent) + "'")
+	}
+	return nil
+}
+
+// Returns the non-negative number from the environment variable
+func loadLimit(key string, def string) (int, error) {
+	limit, err := strconv.Atoi(getEnvOrDefault(key, def))
+	if err != nil || limit < 0 {
+		return 0, errors.New("Not a valid number in " + key + ": '" + os.Getenv(key) + "'")
+	}
+	return limit, nil
+}
+
+// Moves the unassign operations which exceed the limits from the plan to the blocked operations, together with
+// the remove-from-org operations which depend on them. Every exceeded limit is logged as ALERT
+// roleHolders holds the number of users with the role before the plan is applied, by role target key
+// Returns the number of blocked revocations
+func (p *syncPlan) enforceRevocationLimits(limits revocationLimits, roleHolders map[string]int) int {
+	// Count the revocations per Org/Space role
+	unassigns := p.opsFor(ActionUnassign)
+	perTarget := make(map[string][]planOp)
+	var keys []string
+	for _, op := range unassigns {
+		key := op.targetKey()
+		if _, ok := perTarget[key]; !ok {
+			keys = append(keys, key)
+		}
+		perTarget[key] = append(perTarget[key], op)
+	}
+	blocked := make(map[string]bool)
+	for _, key := range keys {
+		ops := perTarget[key]
+		target := describeTarget(roleTarget{OrgName: ops[0].Org, SpaceName: ops[0].Space, Role: ops[0].Role})
+		if limits.PerGroup > 0 && len(ops) > limits.PerGroup {
+			log.Printf("ALERT: %d roles would be unset for %v, which is more than %v=%d. No roles are unset for it.\n",
+				len(ops), target, EnvRevocationMaxPerGroup, limits.PerGroup)
+			blocked[key] = true
+		} else if limits.Percent > 0 && len(ops) > 1 && len(ops)*100 > limits.Percent*roleHolders[key] {
+			log.Printf("ALERT: %d of %d users would lose %v, which is more than %v=%d%%. No roles are unset for it.\n",
+				len(ops), roleHolders[key], target, EnvRevocationMaxPercent, limits.Percent)
+			blocked[key] = true
+		}
+	}
+	remaining := 0
+	for _, op := range unassigns {
+		if !blocked[op.targetKey()] {
+			remaining++
+		}
+	}
+	blockAll := limits.PerCycle > 0 && remaining > limits.PerCycle
+	if blockAll {
+		log.Printf("ALERT: %d roles would be unset in this cycle, which is more than %v=%d. No roles are unset at all.\n",
+			remaining, EnvRevocationMaxPerCycle, limits.PerCycle)
+	}
+	if len(blocked) == 0 && !blockAll {
+		return 0
+	}
+	withheld := p.withholdUnassigns(func(op planOp) bool {
+		return op.Action == ActionUnassign && (blockAll || blocked[op.targetKey()])
+	})
+	p.Blocked = append(p.Blocked, withheld...)
+	log.Println("ALERT: Revocations were blocked by the safety limits. Check the sources, or run 'gmapper sync --once --force-revocations' when the revocations are intended.")
+	return countAction(withheld, ActionUnassign)
+}
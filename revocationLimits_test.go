@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+)
+
+// Returns the operation which unsets the role of the target for the user
+func unassignOp(target roleTarget, user string) planOp {
+	return planOp{Action: ActionUnassign, User: user, Org: target.OrgName, Space: target.SpaceName, Role: target.Role,
+		userGuid: "guid-" + user, orgGuid: target.OrgGuid, spaceGuid: target.SpaceGuid, cfRole: target.CfRole}
+}
+
+// Returns the operation which removes the user from the org 'org'
+func removeFromOrgOp(user string) planOp {
+	return planOp{Action: ActionRemoveFromOrg, User: user, Org: "org", userGuid: "guid-" + user, orgGuid: "org-1"}
+}
+
+// Returns a sorted plan with the operations
+func planWith(ops ...planOp) *syncPlan {
+	plan := &syncPlan{Ops: ops}
+	plan.sort()
+	return plan
+}
+
+func TestEnforceRevocationLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  revocationLimits
+		plan    *syncPlan
+		holders map[string]int
+		// Number of blocked revocations
+		count    int
+		expected []string
+		blocked  []string
+	}{
+		{
+			name:     "limits disabled",
+			plan:     planWith(unassignOp(orgManager, "alice"), unassignOp(orgManager, "bob"), removeFromOrgOp("bob")),
+			holders:  map[string]int{orgManager.key(): 2},
+			expected: []string{"unassign alice org orgmanager", "unassign bob org orgmanager", "remove-from-org bob org"},
+		},
+		{
+			name:   "per group",
+			limits: revocationLimits{PerGroup: 1},
+			plan: planWith(unassignOp(orgManager, "alice"), unassignOp(orgManager, "bob"), unassignOp(orgAuditor, "carol"),
+				removeFromOrgOp("bob"), removeFromOrgOp("carol")),
+			holders:  map[string]int{orgManager.key(): 10, orgAuditor.key(): 10},
+			count:    2,
+			expected: []string{"unassign carol org auditor", "remove-from-org carol org"},
+			blocked:  []string{"unassign alice org orgmanager", "unassign bob org orgmanager", "remove-from-org bob org"},
+		},
+		{
+			name:    "percentage",
+			limits:  revocationLimits{Percent: 50},
+			plan:    planWith(unassignOp(orgManager, "alice"), unassignOp(orgManager, "bob")),
+			holders: map[string]int{orgManager.key(): 2},
+			count:   2,
+			blocked: []string{"unassign alice org orgmanager", "unassign bob org orgmanager"},
+		},
+		{
+			name:     "percentage below the limit",
+			limits:   revocationLimits{Percent: 50},
+			plan:     planWith(unassignOp(orgManager, "alice"), unassignOp(orgManager, "bob")),
+			holders:  map[string]int{orgManager.key(): 4},
+			expected: []string{"unassign alice org orgmanager", "unassign bob org orgmanager"},
+		},
+		{
+			name:     "a single revocation is always allowed",
+			limits:   revocationLimits{Percent: 10},
+			plan:     planWith(unassignOp(orgManager, "alice")),
+			holders:  map[string]int{orgManager.key(): 1},
+			expected: []string{"unassign alice org orgmanager"},
+		},
+		{
+			name:    "per cycle",
+			limits:  revocationLimits{PerCycle: 2},
+			plan:    planWith(unassignOp(orgManager, "alice"), unassignOp(orgAuditor, "bob"), unassignOp(liveDeveloper, "carol")),
+			holders: map[string]int{orgManager.key(): 10, orgAuditor.key(): 10, liveDeveloper.key(): 10},
+			count:   3,
+			blocked: []string{"unassign bob org auditor", "unassign alice org orgmanager", "unassign carol org/live spacedeveloper"},
+		},
+		{
+			name:     "per cycle only counts the revocations which are not blocked per group",
+			limits:   revocationLimits{PerGroup: 2, PerCycle: 2},
+			plan:     planWith(unassignOp(orgManager, "alice"), unassignOp(orgManager, "bob"), unassignOp(orgManager, "carol"), unassignOp(orgAuditor, "dave"), unassignOp(orgAuditor, "erin")),
+			holders:  map[string]int{orgManager.key(): 10, orgAuditor.key(): 10},
+			count:    3,
+			expected: []string{"unassign dave org auditor", "unassign erin org auditor"},
+			blocked:  []string{"unassign alice org orgmanager", "unassign bob org orgmanager", "unassign carol org orgmanager"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			count := test.plan.enforceRevocationLimits(test.limits, test.holders)
+			if count != test.count {
+				t.Errorf("expected %d blocked revocations, got %d", test.count, count)
+			}
+			expectOps(t, "planned", test.plan.Ops, test.expected)
+			expectOps(t, "blocked", test.plan.Blocked, test.blocked)
+		})
+	}
+}
+
+func TestLoadRevocationLimits(t *testing.T) {
+	defer setEnv(EnvRevocationMaxPerGroup, "")()
+	defer setEnv(EnvRevocationMaxPerCycle, "")()
+	defer setEnv(EnvRevocationMaxPercent, "")()
+	// The limits are disabled unless they are set
+	if err := loadRevocationLimits(); err != nil || limits != (revocationLimits{}) {
+		t.Errorf("expected no limits by default, got %+v (%v)", limits, err)
+	}
+	defer setEnv(EnvRevocationMaxPerGroup, "10")()
+	defer setEnv(EnvRevocationMaxPercent, "50")()
+	if err := loadRevocationLimits(); err != nil || limits != (revocationLimits{PerGroup: 10, Percent: 50}) {
+		t.Errorf("unexpected limits %+v (%v)", limits, err)
+	}
+	defer setEnv(EnvRevocationMaxPercent, "101")()
+	if err := loadRevocationLimits(); err == nil {
+		t.Error("expected an error for a percentage over 100")
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/SpringerPE/cf-user-role-syncher/cfclient"
+)
+
+// Declaration of environment variable key names
+const EnvScimEndPoint string = "SCIMENDPOINT"
+const EnvScimToken string = "SCIMTOKEN"
+const EnvScimGroupFilter string = "SCIMGROUPFILTER"
+const EnvScimPageSize string = "SCIMPAGESIZE"
+
+// Structure for a SCIM list response (e.g. when searching groups)
+type ScimListResponse struct {
+	TotalResults int               `json:"totalResults"`
+	ItemsPerPage int               `json:"itemsPerPage"`
+	StartIndex   int               `json:"startIndex"`
+	Resources    []json.RawMessage `json:"Resources"`
+}
+
+// Structure for a single SCIM group
+type ScimGroup struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+}
+
+// MembershipSource implementation for SCIM 2.0 servers (e.g. Okta, Azure AD or Keycloak)
+type scimSource struct {
+	endpoint    string
+	token       string
+	groupFilter string
+	pageSize    int
+	httpClient  *http.Client
+}
+
+// Creates a new scimSource using the values from the environment variables
+func newScimSource() (*scimSource, error) {
+	if os.Getenv(EnvScimEndPoint) == "" {
+		return nil, errors.New("Environment variable " + EnvScimEndPoint + " must be set")
+	}
+	pageSize, err := strconv.Atoi(getEnvOrDefault(EnvScimPageSize, "100"))
+	if err != nil || pageSize < 1 {
+		return nil, errors.New("Not a valid page size in " + EnvScimPageSize + ": '" + os.Getenv(EnvScimPageSize) + "'")
+	}
+	return &scimSource{
+		endpoint:    strings.TrimSuffix(os.Getenv(EnvScimEndPoint), "/"),
+		token:       os.Getenv(EnvScimToken),
+		groupFilter: getEnvOrDefault(EnvScimGroupFilter, "displayName sw "+cfclient.ScimQuote(groupNames.namePrefix())),
+		pageSize:    pageSize,
+		httpClient:  &http.Client{},
+	}, nil
+}
+
+func (s *scimSource) ListGroups() ([]SourceGroup, error) {
+	var groups []SourceGroup
+	// SCIM pages are 1-based
+	startIndex := 1
+	for {
+		q := url.Values{}
+		q.Add("filter", s.groupFilter)
+		q.Add("attributes", "id,displayName")
+		q.Add("startIndex", strconv.Itoa(startIndex))
+		q.Add("count", strconv.Itoa(s.pageSize))
+		var page ScimListResponse
+		if err := s.get("/Groups", &q, &page); err != nil {
+			return groups, err
+		}
+		for _, resource := range page.Resources {
+			var group ScimGroup
+			if err := json.Unmarshal(resource, &group); err != nil {
+				return groups, err
+			}
+			groups = append(groups, SourceGroup{Name: group.DisplayName, ID: group.ID})
+		}
+		// Stop when the last page has been read
+		startIndex += len(page.Resources)
+		if len(page.Resources) == 0 || startIndex > page.TotalResults {
+			break
+		}
+	}
+	return groups, nil
+}
+
+// Lists the users which are member of the group, page by page
+// The users are searched by their groups instead of reading the members of the group: the members of a group are
+// not paged and only hold the user IDs, and servers may leave them out or cut them off for large groups
+// SCIM servers list both direct and indirect (nested) group memberships of a user
+func (s *scimSource) ListMembers(group SourceGroup) ([]Member, error) {
+	var members []Member
+	// SCIM pages are 1-based
+	startIndex := 1
+	for {
+		q := url.Values{}
+		q.Add("filter", "groups.value eq "+cfclient.ScimQuote(group.ID))
+		q.Add("attributes", "id,userName,emails")
+		q.Add("startIndex", strconv.Itoa(startIndex))
+		q.Add("count", strconv.Itoa(s.pageSize))
+		var page ScimListResponse
+		if err := s.get("/Users", &q, &page); err != nil {
+			return members, err
+		}
+		for _, resource := range page.Resources {
+			var user cfclient.UAAUser
+			if err := json.Unmarshal(resource, &user); err != nil {
+				return members, err
+			}
+			email := getScimUserEmail(&user)
+			if email == "" {
+				log.Println("Member '" + user.UserName + "' of group '" + group.Name + "' has no email address. Skipping.")
+				continue // Try next member
+			}
+			members = append(members, Member{Email: strings.ToLower(email)})
+		}
+		startIndex += len(page.Resources)
+		if startIndex > page.TotalResults {
+			break
+		}
+		// The server stopped returning members before all of them were listed
+		if len(page.Resources) == 0 {
+			return members, &incompleteListingError{group: group.Name,
+				reason: "the SCIM server returned " + strconv.Itoa(startIndex-1) + " of " + strconv.Itoa(page.TotalResults) + " members"}
+		}
+	}
+	return members, nil
+}
+
+// Sends a GET request to the SCIM server and parses the json response into v
+func (s *scimSource) get(path string, querystring *url.Values, v interface{}) error {
+	req, err := http.NewRequest("GET", s.endpoint+path, nil)
+	if err != nil {
+		return err
+	}
+	if querystring != nil {
+		req.URL.RawQuery = querystring.Encode()
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	req.Header.Set("Accept", "application/scim+json, application/json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return errors.New("SCIM request for '" + path + "' failed with HTTP status code " + strconv.Itoa(resp.StatusCode))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/SpringerPE/cf-user-role-syncher/cfclient"
+)
+
+// Starts a SCIM server with the users of a single group
+// The server returns at most maxResults users per page, and stops after total users even when it announces more
+func newTestScimServer(t *testing.T, users []cfclient.UAAUser, maxResults int, total int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(401)
+			return
+		}
+		if r.URL.Path != "/Users" || r.URL.Query().Get("filter") != `groups.value eq "group-1"` {
+			w.WriteHeader(400)
+			return
+		}
+		startIndex, _ := strconv.Atoi(r.URL.Query().Get("startIndex"))
+		count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+		if count > maxResults {
+			count = maxResults
+		}
+		page := ScimListResponse{TotalResults: len(users), StartIndex: startIndex}
+		for i := startIndex - 1; i < len(users) && i < total && i < startIndex-1+count; i++ {
+			resource, _ := json.Marshal(users[i])
+			page.Resources = append(page.Resources, resource)
+		}
+		page.ItemsPerPage = len(page.Resources)
+		if err := json.NewEncoder(w).Encode(page); err != nil {
+			t.Error(err)
+		}
+	}))
+}
+
+func scimUser(id string, userName string, emails ...string) cfclient.UAAUser {
+	user := cfclient.UAAUser{ID: id, UserName: userName}
+	for i, email := range emails {
+		user.Emails = append(user.Emails, cfclient.UAAEmail{Value: email, Primary: i == len(emails)-1})
+	}
+	return user
+}
+
+func TestScimListMembers(t *testing.T) {
+	var many []cfclient.UAAUser
+	var manyEmails []string
+	for i := 0; i < 7; i++ {
+		email := "user" + strconv.Itoa(i) + "@example.com"
+		many = append(many, scimUser(strconv.Itoa(i), "user"+strconv.Itoa(i), email))
+		manyEmails = append(manyEmails, email)
+	}
+	tests := []struct {
+		name       string
+		users      []cfclient.UAAUser
+		maxResults int
+		total      int
+		expected   []string
+		incomplete bool
+	}{
+		{
+			name:       "primary email, userName and no email",
+			users:      []cfclient.UAAUser{scimUser("1", "alice", "alice@private.com", "Alice@Example.com"), scimUser("2", "Bob@example.com"), scimUser("3", "carol")},
+			maxResults: 100, total: 100,
+			expected: []string{"alice@example.com", "bob@example.com"},
+		},
+		{
+			name:       "several pages",
+			users:      many,
+			maxResults: 100, total: 100,
+			expected: manyEmails,
+		},
+		{
+			name:       "smaller pages than requested",
+			users:      many,
+			maxResults: 2, total: 100,
+			expected: manyEmails,
+		},
+		{
+			name:       "truncated listing",
+			users:      many,
+			maxResults: 100, total: 4,
+			expected:   manyEmails[:4],
+			incomplete: true,
+		},
+		{
+			name:       "empty group",
+			maxResults: 100, total: 100,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestScimServer(t, test.users, test.maxResults, test.total)
+			defer server.Close()
+			source := &scimSource{endpoint: server.URL, token: "secret", pageSize: 3, httpClient: server.Client()}
+			members, err := source.ListMembers(SourceGroup{Name: "cfroles__org__orgmanager", ID: "group-1"})
+			if test.incomplete != isIncompleteListing(err) || (err != nil && !isIncompleteListing(err)) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if emails := memberEmails(members); strings.Join(emails, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("expected %v, got %v", test.expected, emails)
+			}
+		})
+	}
+}
+
+func TestScimListMembersError(t *testing.T) {
+	server := newTestScimServer(t, nil, 100, 100)
+	defer server.Close()
+	source := &scimSource{endpoint: server.URL, token: "wrong", pageSize: 3, httpClient: server.Client()}
+	if _, err := source.ListMembers(SourceGroup{Name: "cfroles__org__orgmanager", ID: "group-1"}); err == nil || isIncompleteListing(err) {
+		t.Errorf("expected an error, got %v", err)
+	}
+}
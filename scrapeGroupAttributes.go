@@ -2,31 +2,79 @@ package main
 
 import (
 	"errors"
+	"strconv"
 	"strings"
+
+	"gopkg.in/yaml.v2"
 )
 
-func scrapeGroupAttributes(email string) (*Group, error) {
+// Structure for the bindings in a group description
+// The description must contain a list of bindings under the 'cfroles' key
+type groupDescription struct {
+	CfRoles []struct {
+		Org   string `yaml:"org"`
+		Space string `yaml:"space"`
+		Role  string `yaml:"role"`
+	} `yaml:"cfroles"`
+}
+
+// Returns the Org, Space and Role bindings of the group
+// A group can have several bindings when they are listed in its description
+// Otherwise, the single binding is scraped from the group name
+func scrapeGroupAttributes(sourceGroup SourceGroup) ([]*Group, error) {
+	// The source already knows the bindings
+	if len(sourceGroup.Groups) > 0 {
+		return sourceGroup.Groups, nil
+	}
+	// Check the description for bindings
+	groups, err := scrapeGroupDescription(sourceGroup)
+	if err != nil || len(groups) > 0 {
+		return groups, err
+	}
 	// Get the part of the group email address before the '@'
+	email := sourceGroup.Name
 	mailboxName := strings.Split(email, "@")[0]
 	// Split the mailboxName to get org, space and role
-	groupAttr := strings.Split(mailboxName, "__")
-	var org, space, role string
-	// 3 items in group email = Org role
-	// 4 items in group email = Space role
-	if len(groupAttr) == 3 {
-		role = groupAttr[2]
-	} else if len(groupAttr) == 4 {
-		space = groupAttr[2]
-		role = groupAttr[3]
-	} else {
-		return nil, errors.New("Not a valid group email format for email: " + email)
-	}
-	org = groupAttr[1]
-	var group = Group{
-		Org:   org,
-		Space: space,
-		Role:  role,
-	}
-	//fmt.Println(&group)
-	return &group, nil
+	// How they are encoded is configured by the group name template
+	group, err := groupNames.parse(mailboxName)
+	if err != nil {
+		return nil, errors.New("Not a valid group email format for email " + email + ": " + err.Error())
+	}
+	return []*Group{group}, nil
+}
+
+// Returns the bindings listed in the description of the group (YAML or JSON)
+// Returns no bindings when the description is not a mapping with a 'cfroles' key, e.g. free text which
+// only mentions cfroles. Only the bindings under that key must be valid
+func scrapeGroupDescription(sourceGroup SourceGroup) ([]*Group, error) {
+	var groups []*Group
+	if !strings.Contains(sourceGroup.Description, "cfroles") {
+		return groups, nil
+	}
+	var mapping map[string]interface{}
+	if err := yaml.Unmarshal([]byte(sourceGroup.Description), &mapping); err != nil {
+		return groups, nil
+	}
+	if _, ok := mapping["cfroles"]; !ok {
+		return groups, nil
+	}
+	var description groupDescription
+	if err := yaml.Unmarshal([]byte(sourceGroup.Description), &description); err != nil {
+		return groups, errors.New("Could not parse bindings in description of group " + sourceGroup.Name + ": " + err.Error())
+	}
+	for i, binding := range description.CfRoles {
+		group := &Group{
+			Org:   binding.Org,
+			Space: binding.Space,
+			Role:  binding.Role,
+		}
+		if group.Org == "" {
+			return nil, errors.New("Missing org for binding " + strconv.Itoa(i+1) + " in description of group " + sourceGroup.Name)
+		}
+		if err := validateGroupRole(group); err != nil {
+			return nil, errors.New(err.Error() + " in description of group " + sourceGroup.Name)
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Returns the bindings as short strings like "org/live/spacedeveloper"
+func describeBindings(groups []*Group) string {
+	var bindings []string
+	for _, g := range groups {
+		bindings = append(bindings, getGroupKey(g))
+	}
+	return strings.Join(bindings, ",")
+}
+
+func TestScrapeGroupAttributes(t *testing.T) {
+	useTestGroupNames(t)
+	tests := []struct {
+		name        string
+		group       SourceGroup
+		expected    string
+		invalid     bool
+		errContains string
+	}{
+		{
+			name:     "group name",
+			group:    SourceGroup{Name: "cfroles__org__live__spacedeveloper@example.com"},
+			expected: "org/live/spacedeveloper",
+		},
+		{
+			name:     "free text mentioning cfroles",
+			group:    SourceGroup{Name: "cfroles__org__auditor@example.com", Description: "Auditors, managed via cfroles sync"},
+			expected: "org/auditor",
+		},
+		{
+			name:     "mapping without cfroles key",
+			group:    SourceGroup{Name: "cfroles__org__auditor@example.com", Description: "owner: cfroles team"},
+			expected: "org/auditor",
+		},
+		{
+			name: "yaml bindings",
+			group: SourceGroup{Name: "cfroles__team@example.com",
+				Description: "cfroles:\n- org: org\n  role: orgmanager\n- org: org\n  space: live\n  role: spacedeveloper\n"},
+			expected: "org/orgmanager,org/live/spacedeveloper",
+		},
+		{
+			name:     "json bindings",
+			group:    SourceGroup{Name: "cfroles__team@example.com", Description: `{"cfroles": [{"org": "org", "role": "auditor"}]}`},
+			expected: "org/auditor",
+		},
+		{
+			name:        "binding without org",
+			group:       SourceGroup{Name: "cfroles__org__auditor@example.com", Description: "cfroles:\n- role: orgmanager\n"},
+			invalid:     true,
+			errContains: "Missing org",
+		},
+		{
+			name:        "binding with unknown role",
+			group:       SourceGroup{Name: "cfroles__org__auditor@example.com", Description: "cfroles:\n- org: org\n  role: superuser\n"},
+			invalid:     true,
+			errContains: "description of group",
+		},
+		{
+			name:        "bindings which are not a list",
+			group:       SourceGroup{Name: "cfroles__org__auditor@example.com", Description: "cfroles: orgmanager"},
+			invalid:     true,
+			errContains: "Could not parse bindings",
+		},
+		{
+			name:    "invalid group name",
+			group:   SourceGroup{Name: "cfroles__org@example.com"},
+			invalid: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			groups, err := scrapeGroupAttributes(test.group)
+			if test.invalid {
+				if err == nil || !strings.Contains(err.Error(), test.errContains) {
+					t.Errorf("expected an error containing '%v', got %v", test.errContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := describeBindings(groups); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
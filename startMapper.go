@@ -1,96 +1,253 @@
 package main
 
 import (
+	"errors"
 	"log"
-
-	"github.com/SpringerPE/cf-user-role-syncher/token"
-	"golang.org/x/net/context"
-	"google.golang.org/api/admin/directory/v1"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-func startMapper() {
-	// Beginning of infinite loop, in order to have the app run forever
-	for {
-		// Load oauth.Config (e.g. Google oauth endpoint)
-		oauthConf := token.GetOauthConfig()
-		// Load oauth.Token for Google (e.g RefreshToken)
-		oauthTok := token.GetOauthToken()
-		// Create 'Service' so Google Directory (Admin) can be requested
-		httpClient := oauthConf.Client(context.Background(), oauthTok)
-		googleService, err := admin.New(httpClient)
+// Declaration of environment variable key names
+const EnvSyncInterval string = "SYNCINTERVAL"
+const EnvSyncJitter string = "SYNCJITTER"
+
+// Options of 'gmapper sync'
+type syncOptions struct {
+	// Run a single sync cycle and exit
+	Once bool
+	// Only read, and print the plan instead of applying it. Implies Once
+	DryRun bool
+	// Format of the plan printed by a dry run (OutputText or OutputJson)
+	Output string
+	// Unset roles even when the revocation limits are exceeded, for intentional bulk removals
+	ForceRevocations bool
+}
+
+// Runs sync cycles until the app receives SIGTERM or SIGINT. With once, only a single sync cycle is run
+// Returns the exit code for the app
+func startMapper(opts syncOptions) int {
+	// Load the configuration of how group names are parsed
+	if err := loadGroupNameGrammar(); err != nil {
+		log.Fatalf("Unable to load group name template: %v", err)
+	}
+	// Load the table with aliases for org and space names
+	if err := loadAliasTable(); err != nil {
+		log.Fatalf("Unable to load alias file: %v", err)
+	}
+	// Load the time between two sync cycles
+	interval, jitter, err := loadSyncInterval()
+	if err != nil {
+		log.Fatalf("Unable to load sync interval: %v", err)
+	}
+	// Load how many groups and members are synced at the same time
+	if err := loadSyncConcurrency(); err != nil {
+		log.Fatalf("Unable to load sync concurrency: %v", err)
+	}
+	// Load the users and roles which are never unset
+	if err := loadProtectedRules(); err != nil {
+		log.Fatalf("Unable to load protected file: %v", err)
+	}
+	// Load the grace period before roles of missing users are unset
+	if err := loadGracePeriod(); err != nil {
+		log.Fatalf("Unable to load grace period: %v", err)
+	}
+	// Load the safety limits for unsetting roles
+	if err := loadRevocationLimits(); err != nil {
+		log.Fatalf("Unable to load revocation limits: %v", err)
+	}
+	// Create the rate limiters, which are shared by all requests to the same backend
+	if err := loadRateLimiters(); err != nil {
+		log.Fatalf("Unable to load rate limits: %v", err)
+	}
+	// Create the client for CF and UAA, which also determines which CF API version to use
+	cf, err = newCfClient()
+	if err != nil {
+		log.Fatalf("Unable to create CF client: %v", err)
+	}
+	if opts.DryRun {
+		// Makes sure nothing is changed in CF/UAA, not even by accident
+		cf.ReadOnly = true
+		opts.Once = true
+	}
+	// Create the source for group memberships
+	source, err := newMembershipSource()
+	if err != nil {
+		log.Fatalf("Unable to create membership source: %v", err)
+	}
+	// Only the leader syncs, the other instances stand by
+	// A dry run doesn't change anything, so it doesn't need (nor take away) the leadership
+	var elector leaderElector = noLeaderElector{}
+	if !opts.DryRun {
+		elector, err = newLeaderElector()
 		if err != nil {
-			log.Fatalf("Unable to create new Google Service (Google client) instance: %v", err)
+			log.Fatalf("Unable to create leader election: %v", err)
 		}
-		// Search for all Google Groups matching the search pattern
-		groupsRes, err := googleService.Groups.List().Customer("my_customer").Query("email:snpaas__*").Do()
-		if err != nil {
-			log.Fatalf("Unable to retrieve Google Groups: %v", err) // Exit program
+	}
+	defer func() {
+		if err := elector.release(); err != nil {
+			log.Printf("Unable to release leadership: %v\n", err)
 		}
-		if len(groupsRes.Groups) == 0 {
-			log.Fatalln("No groups found.")
-		} else {
-			// Loop over all found groups
-			for _, gr := range groupsRes.Groups {
-				log.Printf("GROUP EMAIL: %v\n", gr.Email)
-				// Get group attributes
-				group, err := scrapeGroupAttributes(gr.Email)
-				if err != nil {
-					log.Printf("Could not scrape group attributes: %v\n", err)
-					continue // Try next group
-				}
-				// Get Org GUID from CF
-				group.CfOrgGuid, err = getOrgGuid(group.Org)
-				if err != nil {
-					log.Printf("Could not get Org GUID: %v\n", err)
-					continue // Try next group
-				}
-				// Search members within this group
-				groupMembersRes, err := googleService.Members.List(gr.Email).Do()
-				if err != nil {
-					log.Fatalf("Unable to retrieve members in group: %v", err) // Exit program
-				}
-				if len(groupMembersRes.Members) == 0 {
-					log.Println("No members found.")
-				} else {
-					// Loop over all found members within this one group
-					for _, m := range groupMembersRes.Members {
-						// First make sure the username exists on CF/UAA side
-						if err := createShadowUserCF(m.Email); err != nil {
-							log.Printf("Could not create new user in CF/UAA for user '"+m.Email+"': %v\n", err)
-							continue // Try next member
-						}
-						// Start process of assigning the right CF Org/Space role to this member
-						if err := assignRole(group, m.Email); err != nil {
-							log.Printf("Could not assign role for user '"+m.Email+"': %v\n", err)
-							continue // Try next member
-						}
-					} // End for (members)
-				} // End if (members)
-				//
-				// Unset the role for users who are not member of the group anymore
-				// Get the role members in CF (so we can compare with the group members)
-				roleMembers, err := getCfRoleMembers(group)
-				if err != nil {
-					log.Printf("Could not get list of existing role members from CF: %v\n", err)
-					continue // Try next group
-				}
-				// Get a list of usernames which need the role to be unset for
-				// (essentially the diff between the group members and role members in CF)
-				unauthorizedUsers := getRoleMembersDiff(roleMembers, groupMembersRes.Members)
-				// Unset the role for every user in the unauthorizedUsers list
-				// And try to remove the user from the org when it doesn't have any role anymore
-				for _, username := range unauthorizedUsers {
-					if err := unsetRole(group, username); err != nil {
-						log.Printf("Could not unset role for user '"+username+"': %v\n", err)
-						continue // Try to unset role for next user
-					}
-					if err := removeUserFromOrg(group, username); err != nil {
-						log.Printf("Could not remove user '"+username+"' from org: %v\n", err)
-						continue // Try to unset role for next user
-					}
+	}()
+	// On SIGTERM (e.g. 'cf stop') or SIGINT, the changes which are being applied are finished before exiting
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %v. Will stop after the changes in progress.\n", sig)
+		close(stop)
+	}()
+	// Beginning of the loop, in order to have the app run until it is stopped
+	for {
+		leader, err := elector.acquire()
+		if err != nil {
+			// Another instance might be the leader, so this instance doesn't sync (see shouldContinue)
+			log.Printf("Unable to determine leadership: %v. Standing by.\n", err)
+			if opts.Once {
+				return ExitSyncErrors
+			}
+		} else if leader {
+			summary := syncAllGroups(source, stop, elector, opts)
+			summary.log()
+			// Report how much time was spent waiting for the rate limiters in this cycle
+			logRateLimiterStats()
+			if opts.Once {
+				return summary.exitCode()
+			}
+			if summary.Interrupted {
+				select {
+				case <-stop:
+					return ExitOk
+				default:
+					// Leadership was lost. Stand by until the next cycle
 				}
-			} // End for (groups)
-		} // End else
-		//unmarshalJson(listAllSpacesInAnOrg("engineering-enablement"))
-	} // End infinite loop
+			}
+		} else {
+			log.Println("Another instance is the leader. Standing by.")
+			if opts.Once {
+				return ExitOk
+			}
+		}
+		// Wait for the next cycle. The jitter spreads the load when several apps start at the same time
+		wait := interval
+		if jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(jitter)))
+		}
+		log.Printf("Next sync cycle in %v\n", wait.Round(time.Second))
+		select {
+		case <-time.After(wait):
+		case <-stop:
+			return ExitOk
+		}
+	} // End loop
 } // End startMapper
+
+// Runs a single sync cycle over all groups of the source
+// First the desired state (from all groups) and the actual state (in CF) are read, then the plan
+// with the differences is made and applied
+// With a dry run, the plan is printed to stdout instead of applied
+// Stops when stop is closed, or when this instance is not the leader anymore
+func syncAllGroups(source MembershipSource, stop <-chan struct{}, elector leaderElector, opts syncOptions) *cycleSummary {
+	summary := &cycleSummary{Started: time.Now()}
+	listings, ok := listAllGroups(source, stop, elector, summary)
+	if !ok {
+		// Without all groups the desired state is not known, so nothing is changed
+		return summary
+	}
+	// The state of the previous cycle. Without it, roles whose group was removed are not unset in this cycle
+	state := loadSyncState(summary)
+	var managed map[string]roleTarget
+	if state != nil {
+		managed = state.ManagedTargets
+	}
+	desired := buildDesiredState(listings, managed, summary)
+	actual := readActualState(desired, summary)
+	plan := buildPlan(desired, actual)
+	// Protected roles and roles within the grace period are left out first, so they don't count for the revocation limits
+	summary.ProtectedRevocations = plan.protect(protectedRules)
+	if gracePeriod > 0 {
+		summary.PendingRevocations = applyGracePeriod(plan, state)
+	}
+	if opts.ForceRevocations {
+		log.Println("Revocation limits are overridden by --force-revocations.")
+	} else {
+		summary.BlockedRevocations = plan.enforceRevocationLimits(limits, actual.roleHolders())
+	}
+	plan.log()
+	// A dry run doesn't store anything
+	if state != nil && !opts.DryRun {
+		state.ManagedTargets = managedTargets(desired, actual)
+		if err := states.save(state); err != nil {
+			log.Printf("Unable to save the state of the sync cycle: %v\n", err)
+			summary.count(&summary.Errors)
+		}
+	}
+	if opts.DryRun {
+		log.Println("Dry run: the plan is not applied.")
+		if err := plan.write(os.Stdout, opts.Output); err != nil {
+			log.Printf("Unable to print the plan: %v\n", err)
+			summary.count(&summary.Errors)
+		}
+		return summary
+	}
+	applyPlan(plan, stop, elector, summary)
+	return summary
+}
+
+// Loads the state of the previous sync cycle. Returns nil when it can't be loaded
+func loadSyncState(summary *cycleSummary) *syncState {
+	state, err := states.load()
+	if err != nil {
+		// The state is not saved in this cycle, so the stored state is kept
+		log.Printf("Unable to load the state of the previous sync cycle: %v\n", err)
+		summary.count(&summary.Errors)
+		return nil
+	}
+	return state
+}
+
+// Holds back the revocations of users who are missing for less than the grace period, and updates the pending removals
+// Returns the number of pending revocations
+func applyGracePeriod(plan *syncPlan, state *syncState) int {
+	if state == nil {
+		// Without the state it is unknown since when users are missing, so all revocations are held back
+		log.Println("The pending removals are unknown. No roles are unset in this cycle.")
+		state, _ = decodeState(nil)
+	}
+	return plan.applyGracePeriod(state, gracePeriod, time.Now())
+}
+
+// Returns false when the app should stop, or when this instance is not the leader anymore
+func shouldContinue(stop <-chan struct{}, elector leaderElector) bool {
+	select {
+	case <-stop:
+		return false
+	default:
+	}
+	// Renew the leadership. Another instance could have taken over, e.g. when the lease expired
+	// When the leadership can't be renewed, another instance might take over, so this instance stops as well
+	if leader, err := elector.acquire(); err != nil {
+		log.Printf("Unable to renew leadership: %v. Stopping this sync cycle.\n", err)
+		return false
+	} else if !leader {
+		log.Println("Another instance has become the leader. Stopping this sync cycle.")
+		return false
+	}
+	return true
+}
+
+// Returns the time between two sync cycles, and the maximum random time which is added to it
+func loadSyncInterval() (time.Duration, time.Duration, error) {
+	interval, err := time.ParseDuration(getEnvOrDefault(EnvSyncInterval, "5m"))
+	if err != nil || interval < 0 {
+		return 0, 0, errors.New("Not a valid duration in " + EnvSyncInterval + ": '" + os.Getenv(EnvSyncInterval) + "'")
+	}
+	jitter, err := time.ParseDuration(getEnvOrDefault(EnvSyncJitter, "30s"))
+	if err != nil || jitter < 0 {
+		return 0, 0, errors.New("Not a valid duration in " + EnvSyncJitter + ": '" + os.Getenv(EnvSyncJitter) + "'")
+	}
+	return interval, jitter, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// leaderElector which returns a fixed result
+type fixedElector struct {
+	leader bool
+	err    error
+}
+
+func (e fixedElector) acquire() (bool, error) { return e.leader, e.err }
+func (e fixedElector) release() error         { return nil }
+
+func TestShouldContinue(t *testing.T) {
+	stopped := make(chan struct{})
+	close(stopped)
+	tests := []struct {
+		name     string
+		stop     chan struct{}
+		elector  leaderElector
+		expected bool
+	}{
+		{name: "leader", stop: make(chan struct{}), elector: fixedElector{leader: true}, expected: true},
+		{name: "not the leader", stop: make(chan struct{}), elector: fixedElector{}, expected: false},
+		{name: "leadership unknown", stop: make(chan struct{}), elector: fixedElector{leader: true, err: errors.New("lease file unreadable")}, expected: false},
+		{name: "stopped", stop: stopped, elector: fixedElector{leader: true}, expected: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := shouldContinue(test.stop, test.elector); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
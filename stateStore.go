@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Declaration of environment variable key names
+const EnvStateFile string = "STATEFILE"
+
+// The state which is kept from one sync cycle to the next
+type syncState struct {
+	// Roles which will be unset after the grace period, by role target key and user GUID
+	PendingRemovals map[string]pendingRemoval `json:"pendingRemovals"`
+	// Roles which are bound to a group, by role target key. When the group is removed, the role is unset for its SSO users
+	ManagedTargets map[string]roleTarget `json:"managedTargets"`
+}
+
+// A role of a user who is missing from the sources
+type pendingRemoval struct {
+	User  string `json:"user"`
+	Org   string `json:"org"`
+	Space string `json:"space,omitempty"`
+	Role  string `json:"role"`
+	// When the user was first found missing
+	Since time.Time `json:"since"`
+}
+
+// Keeps the syncState between sync cycles
+type stateStore interface {
+	// Returns the stored state, or an empty state when nothing was stored yet
+	load() (*syncState, error)
+	save(state *syncState) error
+}
+
+// Returns the stateStore for STATEFILE, or a stateStore in memory when it is not set
+func newStateStore() stateStore {
+	if os.Getenv(EnvStateFile) == "" {
+		return &memoryStateStore{}
+	}
+	return &fileStateStore{path: os.Getenv(EnvStateFile)}
+}
+
+// stateStore which keeps the state in memory, so it is lost when the app restarts
+type memoryStateStore struct {
+	mu    sync.Mutex
+	state []byte
+}
+
+func (s *memoryStateStore) load() (*syncState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return decodeState(s.state)
+}
+
+func (s *memoryStateStore) save(state *syncState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.state = data
+	s.mu.Unlock()
+	return nil
+}
+
+// stateStore which keeps the state in a json file, e.g. on a volume which survives restarts of the app
+type fileStateStore struct {
+	path string
+}
+
+func (s *fileStateStore) load() (*syncState, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	// A missing file means nothing was stored yet
+	return decodeState(data)
+}
+
+func (s *fileStateStore) save(state *syncState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(s.path, data)
+}
+
+// Returns the state from the json data. Empty data means an empty state
+func decodeState(data []byte) (*syncState, error) {
+	state := &syncState{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, err
+		}
+	}
+	if state.PendingRemovals == nil {
+		state.PendingRemovals = make(map[string]pendingRemoval)
+	}
+	if state.ManagedTargets == nil {
+		state.ManagedTargets = make(map[string]roleTarget)
+	}
+	return state, nil
+}
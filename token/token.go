@@ -31,6 +31,10 @@ const EnvGoogleAccessToken string = "GOOGLEACCESSTOKEN"
 const EnvGoogleRefreshToken string = "GOOGLEREFRESHTOKEN"
 const EnvGoogleTokenType string = "GOOGLETOKENTYPE"
 
+// The http client used for getting a new Oauth Access Token for CF
+// Can be replaced, e.g. with a client which retries failed requests
+var HttpClient = http.DefaultClient
+
 // Structure for the API response
 // when getting a new Access Token for CF using the Refresh Token
 type TokenResponse struct {
@@ -61,7 +65,7 @@ func GetCfAccessToken() (string, error) {
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
 	// Do the actual http request
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := HttpClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -71,9 +75,17 @@ func GetCfAccessToken() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("UAA responded with HTTP status code %d: %s", resp.StatusCode, string(bodyBytes))
+	}
 	var tokenresponse TokenResponse
 	// Parse the raw response body into a TokenResponse data structure
-	json.Unmarshal(bodyBytes, &tokenresponse)
+	if err := json.Unmarshal(bodyBytes, &tokenresponse); err != nil {
+		return "", err
+	}
+	if tokenresponse.AccessToken == "" {
+		return "", fmt.Errorf("UAA did not return an Access Token")
+	}
 	return "bearer " + tokenresponse.AccessToken, nil
 }
 
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+)
+
+// Checks if the role of the group is one of the roles the app can assign
+func validateGroupRole(group *Group) error {
+	if group.Space != "" {
+		// Space role
+		if _, ok := spaceRoleMap[group.Role]; !ok {
+			return errors.New("Unknown space role '" + group.Role + "' for space '" + group.Space + "' in org '" + group.Org + "'")
+		}
+		return nil
+	}
+	// The spacedeveloper role is allowed without space. It is then assigned for every space in the org
+	if group.Role == "spacedeveloper" {
+		return nil
+	}
+	// Org role
+	if _, ok := orgRoleMap[group.Role]; !ok {
+		return errors.New("Unknown org role '" + group.Role + "' for org '" + group.Org + "'")
+	}
+	return nil
+}
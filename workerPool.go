@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Declaration of environment variable key names
+const EnvSyncConcurrency string = "SYNCCONCURRENCY"
+const EnvSyncMemberConcurrency string = "SYNCMEMBERCONCURRENCY"
+
+// Maximum number of groups which are synced at the same time, and
+// maximum number of members per group which are synced at the same time
+// Set by loadSyncConcurrency
+var groupConcurrency, memberConcurrency int = 1, 1
+
+// Loads the concurrency of syncing from the environment variables
+func loadSyncConcurrency() error {
+	var err error
+	groupConcurrency, err = strconv.Atoi(getEnvOrDefault(EnvSyncConcurrency, "4"))
+	if err != nil || groupConcurrency < 1 {
+		return errors.New("Not a valid number in " + EnvSyncConcurrency + ": '" + os.Getenv(EnvSyncConcurrency) + "'")
+	}
+	memberConcurrency, err = strconv.Atoi(getEnvOrDefault(EnvSyncMemberConcurrency, "4"))
+	if err != nil || memberConcurrency < 1 {
+		return errors.New("Not a valid number in " + EnvSyncMemberConcurrency + ": '" + os.Getenv(EnvSyncMemberConcurrency) + "'")
+	}
+	return nil
+}
+
+// Calls fn for every index from 0 to count-1, with at most concurrency calls running at the same time
+// Returns when all calls are done
+func forEachConcurrently(count int, concurrency int, fn func(i int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < count; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}